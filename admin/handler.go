@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/metrics"
+	"bv-streamer/streamer"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type Admin struct {
+	reload func() error
+}
+
+func Handler(reload func() error) http.Handler {
+	a := &Admin{reload: reload}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", a.handleStatus)
+	mux.HandleFunc("GET /api/cameras/{name}", a.handleCamera)
+	mux.HandleFunc("POST /api/cameras/{name}/start", a.handleStreamer(streamer.StartCamera))
+	mux.HandleFunc("POST /api/cameras/{name}/stop", a.handleStreamer(streamer.StopCamera))
+	mux.HandleFunc("POST /api/cameras/{name}/arm", a.handleAlarm((*alarm.Alarm).Arm))
+	mux.HandleFunc("POST /api/cameras/{name}/disarm", a.handleAlarm((*alarm.Alarm).Disarm))
+	mux.HandleFunc("POST /api/cameras/{name}/record/start", a.handleAlarm((*alarm.Alarm).ForceStart))
+	mux.HandleFunc("POST /api/cameras/{name}/record/stop", a.handleAlarm((*alarm.Alarm).ForceStop))
+	mux.HandleFunc("POST /api/reload", a.handleReload)
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("GET /api/events", func(w http.ResponseWriter, r *http.Request) {
+		events.Serve(w, r, func(*http.Request) bool { return true }, "")
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := config.GetConfigGlobal().AdminToken; token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (a *Admin) handleStatus(w http.ResponseWriter, r *http.Request) {
+	list := make([]streamer.StreamerStatus, 0)
+	for _, c := range config.GetCameras() {
+		if st, found := streamer.Status(c.Name); found {
+			list = append(list, st)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *Admin) handleCamera(w http.ResponseWriter, r *http.Request) {
+	st, found := streamer.Status(r.PathValue("name"))
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown camera")
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (a *Admin) handleStreamer(action func(string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if config.GetCamera(name) == nil {
+			writeError(w, http.StatusNotFound, "unknown camera")
+			return
+		}
+		if err := action(name); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		a.handleCamera(w, r)
+	}
+}
+
+func (a *Admin) handleAlarm(action func(*alarm.Alarm) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if config.GetCamera(name) == nil {
+			writeError(w, http.StatusNotFound, "unknown camera")
+			return
+		}
+		s := streamer.Find(name)
+		if s == nil || s.Alarm() == nil {
+			writeError(w, http.StatusConflict, "tracking not running")
+			return
+		}
+		if err := action(s.Alarm()); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, alarm.ErrStopped) {
+				status = http.StatusConflict
+			}
+			writeError(w, status, err.Error())
+			return
+		}
+		a.handleCamera(w, r)
+	}
+}
+
+func (a *Admin) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := a.reload(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
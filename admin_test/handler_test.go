@@ -0,0 +1,161 @@
+package admin_test
+
+import (
+	"bv-streamer/admin"
+	"bv-streamer/config"
+	"bv-streamer/streamer"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const token = "admin-secret"
+
+func initConfig(t *testing.T, conf string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bv-streamer.conf")
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Init(&path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func do(h http.Handler, method, path, bearer string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if bearer != "" {
+		r.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	initConfig(t, `{"loglevel": "error", "ws_port": 1510, "admin_port": 1511, "admin_token": "`+token+`"}`)
+	h := admin.Handler(func() error { return nil })
+
+	tests := []struct {
+		bearer string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{token + "x", http.StatusUnauthorized},
+		{token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/api/status", "/metrics", "/api/cameras/Garage"} {
+			w := do(h, http.MethodGet, path, tt.bearer)
+			want := tt.want
+			if want == http.StatusOK && path == "/api/cameras/Garage" {
+				want = http.StatusNotFound
+			}
+			if w.Code != want {
+				t.Errorf("GET %s with %q: got %d, want %d", path, tt.bearer, w.Code, want)
+			}
+			if want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("GET %s with %q: missing WWW-Authenticate header", path, tt.bearer)
+			}
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	initConfig(t, `{
+  "loglevel": "error",
+  "ws_port": 1510,
+  "admin_port": 1511,
+  "admin_token": "`+token+`",
+  "cameras": [{"name": "Garage", "ws_path": "/admin_test_garage", "ffmpeg_path": "/bin/false", "tracking": false}]
+}`)
+	var reloadErr error
+	reloads := 0
+	h := admin.Handler(func() error {
+		reloads++
+		return reloadErr
+	})
+	defer streamer.StopCamera("Garage")
+
+	status := func(w *httptest.ResponseRecorder) streamer.StreamerStatus {
+		t.Helper()
+		var st streamer.StreamerStatus
+		if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	w := do(h, http.MethodGet, "/api/status", token)
+	var list []streamer.StreamerStatus
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status: %d %v", w.Code, err)
+	}
+	if len(list) != 1 || list[0].Name != "Garage" || list[0].Running {
+		t.Errorf("unexpected status %+v", list)
+	}
+
+	if w = do(h, http.MethodGet, "/api/cameras/garage", token); w.Code != http.StatusOK || status(w).Name != "Garage" {
+		t.Errorf("camera: got %d", w.Code)
+	}
+
+	if w = do(h, http.MethodPost, "/api/cameras/Garage/start", token); w.Code != http.StatusOK || !status(w).Running {
+		t.Errorf("start: got %d", w.Code)
+	}
+	if w = do(h, http.MethodPost, "/api/cameras/Garage/stop", token); w.Code != http.StatusOK || status(w).Running {
+		t.Errorf("stop: got %d", w.Code)
+	}
+
+	for _, path := range []string{"arm", "disarm", "record/start", "record/stop"} {
+		if w = do(h, http.MethodPost, "/api/cameras/Garage/"+path, token); w.Code != http.StatusConflict {
+			t.Errorf("%s without tracking: got %d, want %d", path, w.Code, http.StatusConflict)
+		}
+	}
+	for _, path := range []string{"start", "stop", "arm", "disarm", "record/start", "record/stop"} {
+		if w = do(h, http.MethodPost, "/api/cameras/Nope/"+path, token); w.Code != http.StatusNotFound {
+			t.Errorf("%s for unknown camera: got %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+		var body map[string]string
+		if json.NewDecoder(w.Body).Decode(&body); body["error"] == "" {
+			t.Errorf("%s for unknown camera: no JSON error", path)
+		}
+	}
+	if w = do(h, http.MethodGet, "/api/cameras/Garage/start", token); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET on start: got %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	if w = do(h, http.MethodPost, "/api/reload", token); w.Code != http.StatusNoContent || reloads != 1 {
+		t.Errorf("reload: got %d after %d reloads", w.Code, reloads)
+	}
+	reloadErr = errors.New("config has errors")
+	if w = do(h, http.MethodPost, "/api/reload", token); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "config has errors") {
+		t.Errorf("failed reload: got %d %s", w.Code, w.Body.String())
+	}
+
+	if w = do(h, http.MethodGet, "/metrics", token); w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("metrics: got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/events", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("events: got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
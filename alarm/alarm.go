@@ -2,13 +2,16 @@ package alarm
 
 import (
 	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/ingest"
+	"bv-streamer/lifecycle"
 	"bv-streamer/log"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -23,11 +26,23 @@ const (
 
 const (
 	ALARM_TIMEOUT = 5 * time.Minute
-	FFMPEG_LOGS   = "ffmpeg_logs"
+
+	// Suffix of an mp4 that is still being remuxed from its .ts.
+	REMUX_TEMP_SUFFIX = ".mp4.tmp"
 )
 
+var ErrStopped = errors.New("alarm stopped")
+
+func (s State) String() string {
+	if s == STATE_ALARM {
+		return "ALARM"
+	}
+	return "IDLE"
+}
+
 type Alarm struct {
 	cfg             *config.ConfigCamera
+	source          EventSource
 	state           State
 	lastAICheck     time.Time
 	lastAIAlarm     time.Time
@@ -37,22 +52,69 @@ type Alarm struct {
 	recCooldown     time.Duration
 	aiCheckInterval time.Duration
 	mdCheckInterval time.Duration
-
-	currOut string
-	ffmpeg  *exec.Cmd
-	stdin   io.WriteCloser
-	mu      sync.Mutex
+	triggers        []Class
+	ingest          *ingest.Ingest
+	preroll         *PreRoll
+
+	storageMu    sync.Mutex
+	storageState storageState
+	storageWarn  int64
+	storageMin   int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+	cmds      chan func()
+	armed     bool
+	forced    bool
+	motion    bool
+
+	statusMu sync.Mutex
+	status   AlarmStatus
+
+	currOut     string
+	currStart   time.Time
+	currClasses []Class
+	recFile     *os.File
+	recorder    *Recorder
+	mu          sync.Mutex
 }
 
-func NewAlarm(conf *config.ConfigCamera) *Alarm {
+func NewAlarm(conf *config.ConfigCamera, src *ingest.Ingest) *Alarm {
+
+	source, err := NewEventSource(conf)
+	if err != nil {
+		log.Errorf("[%s] %v", conf.Name, err)
+		return nil
+	}
 
 	a := Alarm{
+		source:          source,
 		cfg:             conf,
+		ingest:          src,
 		state:           STATE_IDLE,
 		aiCooldown:      time.Second * 14,
 		aiCheckInterval: time.Second * 7,
 		mdCheckInterval: time.Second * 3,
 		recCooldown:     time.Second * 12,
+		triggers:        []Class{CLASS_PEOPLE},
+		storageWarn:     STORAGE_WARN_DEFAULT,
+		storageMin:      STORAGE_MIN_DEFAULT,
+		done:            make(chan struct{}),
+		cmds:            make(chan func()),
+		armed:           true,
+	}
+
+	if len(conf.AiTriggers) > 0 {
+		a.triggers = a.triggers[:0]
+		for _, t := range conf.AiTriggers {
+			c := Class(strings.ToLower(strings.TrimSpace(t)))
+			switch c {
+			case CLASS_PEOPLE, CLASS_VEHICLE, CLASS_DOG_CAT, CLASS_FACE:
+				a.triggers = append(a.triggers, c)
+			default:
+				log.Warnf("[%s] Unknown ai trigger %q ignored.", conf.Name, t)
+			}
+		}
 	}
 
 	if conf.AiCooldown > 0 {
@@ -67,6 +129,14 @@ func NewAlarm(conf *config.ConfigCamera) *Alarm {
 	if conf.ReCooldown > 0 {
 		a.recCooldown = time.Duration(conf.ReCooldown) * time.Second
 	}
+	if conf.StorageWarn > 0 {
+		a.storageWarn = conf.StorageWarn
+	}
+	if conf.StorageMin > 0 {
+		a.storageMin = conf.StorageMin
+	}
+	a.preroll = NewPreRoll(conf.Name, time.Duration(conf.PreRoll)*time.Second)
+	a.snapshot()
 
 	return &a
 }
@@ -81,61 +151,98 @@ func (a *Alarm) Run() {
 		}
 	}
 
-	go func() {
+	a.ingest.Subscribe(a.preroll)
+	defer a.ingest.Unsubscribe(a.preroll)
+
+	lifecycle.Go(a.cfg.Name+"/janitor", a.janitor)
+	lifecycle.Go(a.cfg.Name+"/storage", a.storageWatchdog)
+
+	lifecycle.Go(a.cfg.Name+"/merger", func() {
 		for {
 			next := time.Now().Add(24 * time.Hour)
 			wait := time.Until(time.Date(next.Year(), next.Month(), next.Day(), 0, 30, 0, 0, next.Location()))
 			select {
-			case <-config.SigShutdown:
+			case <-a.done:
 				return
 			case <-time.After(wait):
 				a.dailyMerger()
 			}
 		}
-	}()
+	})
 
+	events.Publish(events.EVENT_ARMED, a.cfg.Name, nil)
 	for {
 		select {
-		case <-config.SigShutdown:
+		case <-a.done:
 			a.stopRec()
+			a.source.Close()
 			return
+		case fn := <-a.cmds:
+			fn()
+			a.snapshot()
 		case <-time.After(a.mdCheckInterval):
+			if !a.armed {
+				continue
+			}
 			motion := a.isMotion()
 			now := time.Now()
+			if motion != a.motion {
+				a.motion = motion
+				if motion {
+					events.Publish(events.EVENT_MOTION_START, a.cfg.Name, nil)
+				} else {
+					events.Publish(events.EVENT_MOTION_STOP, a.cfg.Name, nil)
+				}
+			}
 
 			switch a.state {
 			case STATE_IDLE:
 				if motion && now.Sub(a.lastAICheck) > a.aiCheckInterval && now.Sub(a.lastAIAlarm) > a.aiCooldown {
-					if a.isHuman() {
-						log.Infof("[%s] Human detected! -> Change to ALARM.", a.cfg.Name)
-						a.state = STATE_ALARM
+					if classes := a.detect(); len(classes) > 0 {
+						log.Infof("[%s] Detected %v! -> Change to ALARM.", a.cfg.Name, classes)
 						a.alarmStart = now
 						a.lastAIAlarm = now
 						a.lastMotion = now
 						a.stopRec()
 						a.startRec()
+						a.addClasses(classes)
+						a.setState(STATE_ALARM)
 					}
 					a.lastAICheck = now
 				}
 			case STATE_ALARM:
-				if a.isHuman() {
+				if classes := a.detect(); len(classes) > 0 {
 					a.lastMotion = now
-					log.Debugf("[%s] Still on ALARM.", a.cfg.Name)
-				} else if now.Sub(a.lastMotion) > a.recCooldown {
-					log.Infof("[%s] No human detected for cooldown -> back to IDLE.", a.cfg.Name)
-					a.state = STATE_IDLE
+					a.addClasses(classes)
+					log.Debugf("[%s] Still on ALARM. %v", a.cfg.Name, classes)
+				} else if !a.forced && now.Sub(a.lastMotion) > a.recCooldown {
+					log.Infof("[%s] No %v detected for cooldown -> back to IDLE.", a.cfg.Name, a.triggers)
+					a.setState(STATE_IDLE)
 					a.stopRec()
 				} else {
 					log.Debugf("[%s] Cooldown running, still recording...", a.cfg.Name)
 				}
 			}
 			log.Debugf("[%s] motion: %v, state: %v", a.cfg.Name, motion, a.state)
+			a.snapshot()
 		}
 	}
 
 }
 
+func (a *Alarm) Stop() {
+	a.closeOnce.Do(func() {
+		log.Infof("[%s] Stopping alarm...", a.cfg.Name)
+		close(a.done)
+	})
+}
+
 func (a *Alarm) startRec() {
+	if !a.checkStorage() {
+		log.Errorf("[%s] Not enough storage, refusing to start recording.", a.cfg.Name)
+		return
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -143,47 +250,27 @@ func (a *Alarm) startRec() {
 	output := fmt.Sprintf("%s/rec_%s_%d.ts", a.cfg.RecPath, a.cfg.Name, now.Unix())
 	output = strings.ReplaceAll(output, "\\", "/")
 
-	a.currOut = output
-
 	log.Debugf("[%s] Start recording: %s at %s", a.cfg.Name, output, now.Format(time.RFC3339))
-	if a.ffmpeg != nil {
+	if a.recFile != nil {
 		log.Debugf("[%s] Recorder already run", a.cfg.Name)
 		return
 	}
 
-	a.ffmpeg = exec.Command(
-		a.cfg.FFmpegPath,
-		"-rtsp_transport", "tcp",
-		"-i", a.cfg.RTSPURL,
-		"-c", "copy",
-		"-f", "mpegts",
-		output,
-	)
-
-	path := fmt.Sprintf("%s/%s", a.cfg.RecPath, FFMPEG_LOGS)
-	if _, err := os.Stat(path); err != nil {
-		if err := os.MkdirAll(path, 0755); err != nil {
-			log.Errorf("[%s] %v", a.cfg.Name, err)
-		}
-	}
-
-	logfile := fmt.Sprintf("%s/ffmpeg_%s_%d.log", path, a.cfg.Name, now.Unix())
-	f, err := os.Create(logfile)
-	if err == nil {
-		a.ffmpeg.Stderr = f
-		defer f.Close()
-	} else {
-		log.Errorf("[%s] Could not create ffmpeg log file: %v", a.cfg.Name, err)
-	}
-
-	a.stdin, _ = a.ffmpeg.StdinPipe()
-
-	if err := a.ffmpeg.Start(); err != nil {
-		log.Errorf("[%s] ffmpeg start error: %v", a.cfg.Name, err)
-	} else {
-		log.Debugf("[%s] ffmpeg record started.", a.cfg.Name)
+	f, err := os.Create(output)
+	if err != nil {
+		log.Errorf("[%s] Could not create recording: %v", a.cfg.Name, err)
+		return
 	}
 
+	a.currOut = output
+	a.currStart = now
+	a.currClasses = nil
+
+	a.recorder = NewRecorder(a.cfg.Name, f)
+	a.preroll.Attach(a.recorder)
+	a.recFile = f
+	log.Debugf("[%s] Record started.", a.cfg.Name)
+	events.Publish(events.EVENT_REC_START, a.cfg.Name, map[string]any{"id": recordingID(output)})
 }
 
 func (a *Alarm) stopRec() {
@@ -192,40 +279,52 @@ func (a *Alarm) stopRec() {
 
 	now := time.Now()
 	log.Debugf("[%s] Stop recording at %s", a.cfg.Name, now.Format(time.RFC3339))
-	if a.ffmpeg != nil && a.ffmpeg.Process != nil {
-		if a.stdin != nil {
-			defer a.stdin.Close()
-			if _, err := a.stdin.Write([]byte("q\n")); err != nil {
-				a.ffmpeg.Process.Kill()
-				log.Errorf("[%s] %v", a.cfg.Name, err)
-			}
-		} else {
-			a.ffmpeg.Process.Kill()
-			log.Errorf("[%s] stdin was not set.", a.cfg.Name)
+	if a.recFile != nil {
+		a.preroll.Detach()
+		if err := a.recorder.Close(); err != nil {
+			log.Errorf("[%s] Could not write recording: %v", a.cfg.Name, err)
 		}
-		done := make(chan error, 1)
-		go func() { done <- a.ffmpeg.Wait() }()
-		select {
-		case err := <-done:
-			if err != nil {
-				log.Errorf("[%s] Recorder Wait error: %v", a.cfg.Name, err)
-				a.ffmpeg.Process.Kill()
-			}
-			a.ffmpeg = nil
-			log.Debugf("[%s] Recording stopped.", a.cfg.Name)
-		case <-time.After(5 * time.Second):
-			log.Debugf("[%s] Timeout waiting for recorder exit.", a.cfg.Name)
-			a.ffmpeg.Process.Kill()
-			a.ffmpeg = nil
+		if n := a.recorder.Dropped(); n > 0 {
+			log.Warnf("[%s] Recording is missing %d chunks, the disk could not keep up.", a.cfg.Name, n)
+		}
+		a.recorder = nil
+		var size int64
+		if info, err := a.recFile.Stat(); err == nil {
+			size = info.Size()
+			metricRecBytes.Observe(float64(size), a.cfg.Name)
 		}
+		metricRecSeconds.Observe(now.Sub(a.currStart).Seconds(), a.cfg.Name)
+		if err := a.recFile.Close(); err != nil {
+			log.Errorf("[%s] %v", a.cfg.Name, err)
+		}
+		a.recFile = nil
+		a.writeMeta(now)
+		log.Debugf("[%s] Recording stopped.", a.cfg.Name)
+		events.Publish(events.EVENT_REC_STOP, a.cfg.Name, map[string]any{
+			"id":       recordingID(a.currOut),
+			"duration": now.Sub(a.currStart).Seconds(),
+			"size":     size,
+			"classes":  append([]Class{}, a.currClasses...),
+		})
+
+		a.currClasses = nil
+
+		current := a.currOut
+		release := Hold(current)
+		lifecycle.Go(a.cfg.Name+"/remuxer "+filepath.Base(current), func() {
+			defer release()
+			a.remuxer(current)
+		})
 	}
 
-	go a.remuxer(a.currOut)
-
 }
 
+// remuxer writes the mp4 under a temporary name and renames it when done, so
+// the recordings API never serves a half written mp4 and the janitor can
+// see the remux is still reading the .ts.
 func (a *Alarm) remuxer(current string) {
 	output := strings.TrimSuffix(current, ".ts") + ".mp4"
+	temp := strings.TrimSuffix(current, ".ts") + REMUX_TEMP_SUFFIX
 	ffmpeg := exec.Command(
 		a.cfg.FFmpegPath,
 		"-y",
@@ -233,65 +332,119 @@ func (a *Alarm) remuxer(current string) {
 		"-c:v", "copy",
 		"-an",
 		"-movflags", "+faststart",
-		output,
+		"-f", "mp4",
+		temp,
 	)
-	if err := ffmpeg.Run(); err != nil {
+	err := ffmpeg.Run()
+	if err == nil {
+		err = os.Rename(temp, output)
+	}
+	if err != nil {
+		os.Remove(temp)
+		metricRemuxFailures.Inc(a.cfg.Name)
 		log.Errorf("[%s] Remuxing failed: %v", a.cfg.Name, err)
 	} else {
 		log.Infof("[%s] Remuxed %s -> %s", a.cfg.Name, current, output)
 	}
 }
 
-func (a *Alarm) isMotion() bool {
+func (a *Alarm) setState(s State) {
+	if a.state != s {
+		metricTransitions.Inc(a.cfg.Name, s.String())
 
-	resp, err := http.DefaultClient.Get(fmt.Sprintf("http://%s/api.cgi?cmd=GetMdState&channel=0&user=%s&password=%s", a.cfg.Address, a.cfg.User, a.cfg.Password))
-	if err != nil {
-		log.Errorf("[%s] %v", a.cfg.Name, err)
-		return false
+		a.mu.Lock()
+		data := map[string]any{
+			"start":   a.alarmStart,
+			"classes": append([]Class{}, a.currClasses...),
+		}
+		if a.recFile != nil {
+			data["id"] = recordingID(a.currOut)
+		}
+		a.mu.Unlock()
+
+		if s == STATE_ALARM {
+			events.Publish(events.EVENT_ALARM_START, a.cfg.Name, data)
+		} else {
+			data["duration"] = time.Since(a.alarmStart).Seconds()
+			events.Publish(events.EVENT_ALARM_STOP, a.cfg.Name, data)
+		}
 	}
-	defer resp.Body.Close()
+	a.state = s
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (a *Alarm) isMotion() bool {
+	start := time.Now()
+	motion, err := a.source.Motion()
+	metricPollSeconds.Observe(time.Since(start).Seconds(), a.cfg.Name, "motion")
 	if err != nil {
+		metricPollErrors.Inc(a.cfg.Name, "motion")
 		log.Errorf("[%s] %v", a.cfg.Name, err)
 		return false
 	}
-	var states MdStateEnvelope
-	if err := json.Unmarshal(body, &states); err != nil {
+	return motion
+}
+
+func (a *Alarm) detect() []Class {
+	start := time.Now()
+	detection, err := a.source.Objects()
+	metricPollSeconds.Observe(time.Since(start).Seconds(), a.cfg.Name, "objects")
+	if err != nil {
+		metricPollErrors.Inc(a.cfg.Name, "objects")
 		log.Errorf("[%s] %v", a.cfg.Name, err)
-		return false
+		return nil
 	}
-	if len(states) > 0 {
-		state := states[0]
-		return state.Value.State == 1
+	var classes []Class
+	for _, c := range a.triggers {
+		if detection.Has(c) {
+			classes = append(classes, c)
+		}
 	}
-	return false
+	return classes
+}
 
+func recordingID(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), ".ts")
 }
 
-func (a *Alarm) isHuman() bool {
+func (a *Alarm) addClasses(classes []Class) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	resp, err := http.DefaultClient.Get(fmt.Sprintf("http://%s/api.cgi?cmd=GetAiState&channel=0&user=%s&password=%s", a.cfg.Address, a.cfg.User, a.cfg.Password))
-	if err != nil {
-		log.Errorf("[%s] %v", a.cfg.Name, err)
-		return false
+	var added []Class
+	for _, c := range classes {
+		found := false
+		for _, curr := range a.currClasses {
+			if curr == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a.currClasses = append(a.currClasses, c)
+			added = append(added, c)
+		}
+	}
+	if len(added) > 0 {
+		events.Publish(events.EVENT_DETECTION, a.cfg.Name, map[string]any{"classes": added})
 	}
-	defer resp.Body.Close()
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Errorf("[%s] %v", a.cfg.Name, err)
-		return false
+func (a *Alarm) writeMeta(end time.Time) {
+	if a.currOut == "" {
+		return
 	}
-	var states AiStateEnvelope
-	if err := json.Unmarshal(body, &states); err != nil {
-		log.Errorf("[%s] %v", a.cfg.Name, err)
-		return false
+	meta := RecMetaEnvelope{
+		Camera:  a.cfg.Name,
+		File:    filepath.Base(strings.TrimSuffix(a.currOut, ".ts") + ".mp4"),
+		Start:   a.currStart.Unix(),
+		End:     end.Unix(),
+		Classes: append([]Class{}, a.currClasses...),
 	}
-	if len(states) > 0 {
-		state := states[0]
-		return state.Value.People.AlarmState == 1
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err == nil {
+		err = os.WriteFile(strings.TrimSuffix(a.currOut, ".ts")+".json", data, 0644)
+	}
+	if err != nil {
+		log.Errorf("[%s] Could not write recording metadata: %v", a.cfg.Name, err)
 	}
-	return false
-
 }
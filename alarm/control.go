@@ -0,0 +1,103 @@
+package alarm
+
+import (
+	"bv-streamer/events"
+	"bv-streamer/log"
+	"time"
+)
+
+func (a *Alarm) Status() AlarmStatus {
+	a.statusMu.Lock()
+	st := a.status
+	a.statusMu.Unlock()
+
+	a.mu.Lock()
+	if a.recFile != nil {
+		st.Recording = a.currOut
+		st.Classes = append([]Class{}, a.currClasses...)
+	}
+	a.mu.Unlock()
+	return st
+}
+
+func (a *Alarm) Arm() error {
+	return a.do(func() {
+		log.Infof("[%s] Tracking armed.", a.cfg.Name)
+		a.armed = true
+		events.Publish(events.EVENT_ARMED, a.cfg.Name, nil)
+	})
+}
+
+func (a *Alarm) Disarm() error {
+	return a.do(func() {
+		log.Infof("[%s] Tracking disarmed.", a.cfg.Name)
+		a.armed = false
+		// Run stops polling while disarmed, so motion would otherwise stay on.
+		if a.motion {
+			a.motion = false
+			events.Publish(events.EVENT_MOTION_STOP, a.cfg.Name, nil)
+		}
+		events.Publish(events.EVENT_DISARMED, a.cfg.Name, nil)
+		if a.state == STATE_ALARM && !a.forced {
+			a.setState(STATE_IDLE)
+			a.stopRec()
+		}
+	})
+}
+
+func (a *Alarm) ForceStart() error {
+	return a.do(func() {
+		log.Infof("[%s] Recording forced.", a.cfg.Name)
+		now := time.Now()
+		a.forced = true
+		if a.state != STATE_ALARM {
+			a.alarmStart = now
+		}
+		a.lastMotion = now
+		if a.currentOutput() == "" {
+			a.startRec()
+		}
+		a.setState(STATE_ALARM)
+	})
+}
+
+func (a *Alarm) ForceStop() error {
+	return a.do(func() {
+		log.Infof("[%s] Recording stopped manually.", a.cfg.Name)
+		a.forced = false
+		a.setState(STATE_IDLE)
+		a.stopRec()
+	})
+}
+
+func (a *Alarm) do(fn func()) error {
+	finished := make(chan struct{})
+	select {
+	case a.cmds <- func() { fn(); close(finished) }:
+	case <-a.done:
+		return ErrStopped
+	}
+	select {
+	case <-finished:
+		return nil
+	case <-a.done:
+		return ErrStopped
+	}
+}
+
+func (a *Alarm) snapshot() {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	a.status = AlarmStatus{
+		Armed:       a.armed,
+		State:       a.state.String(),
+		Forced:      a.forced,
+		Source:      a.cfg.EventSource,
+		LastMotion:  a.lastMotion,
+		LastAIAlarm: a.lastAIAlarm,
+		AlarmStart:  a.alarmStart,
+	}
+	if a.status.Source == "" {
+		a.status.Source = SOURCE_REOLINK
+	}
+}
@@ -0,0 +1,53 @@
+package alarm
+
+import (
+	"bv-streamer/config"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Class string
+
+const (
+	CLASS_PEOPLE  Class = "people"
+	CLASS_VEHICLE Class = "vehicle"
+	CLASS_DOG_CAT Class = "dog_cat"
+	CLASS_FACE    Class = "face"
+)
+
+const (
+	SOURCE_REOLINK string = "reolink"
+	SOURCE_ONVIF   string = "onvif"
+)
+
+type Detection struct {
+	Time    time.Time
+	Classes []Class
+}
+
+func (d Detection) Has(c Class) bool {
+	for _, cl := range d.Classes {
+		if cl == c {
+			return true
+		}
+	}
+	return false
+}
+
+type EventSource interface {
+	Motion() (bool, error)
+	Objects() (Detection, error)
+	Close() error
+}
+
+func NewEventSource(cfg *config.ConfigCamera) (EventSource, error) {
+	switch strings.ToLower(cfg.EventSource) {
+	case "", SOURCE_REOLINK:
+		return NewReolinkSource(cfg), nil
+	case SOURCE_ONVIF:
+		return NewOnvifSource(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown event source %q", cfg.EventSource)
+	}
+}
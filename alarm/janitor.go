@@ -0,0 +1,211 @@
+package alarm
+
+import (
+	"bv-streamer/config"
+	"bv-streamer/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JANITOR_INTERVAL = 15 * time.Minute
+	// A temporary mp4 untouched for this long is left over from a crashed
+	// remux and no longer protects its recording.
+	REMUX_STALE = 10 * time.Minute
+)
+
+type retentionGroup struct {
+	files   []string
+	size    int64
+	modTime time.Time
+	archive bool
+	busy    bool
+}
+
+var (
+	heldMu sync.Mutex
+	held   = make(map[string]int)
+)
+
+// Hold marks a recording file as in use, e.g. by a download, so the janitor
+// leaves its recording alone until release is called.
+func Hold(path string) (release func()) {
+	path = filepath.Clean(path)
+	heldMu.Lock()
+	held[path]++
+	heldMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			heldMu.Lock()
+			defer heldMu.Unlock()
+			if held[path]--; held[path] <= 0 {
+				delete(held, path)
+			}
+		})
+	}
+}
+
+func isHeld(path string) bool {
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	return held[filepath.Clean(path)] > 0
+}
+
+func (a *Alarm) janitor() {
+	r := a.cfg.Retention
+	if !retentionEnabled(r) {
+		return
+	}
+	log.Infof("[%s] Retention janitor start.", a.cfg.Name)
+
+	for {
+		a.storageMu.Lock()
+		a.enforceRetention(r.MinFreeBytes)
+		a.storageMu.Unlock()
+		select {
+		case <-a.done:
+			log.Infof("[%s] Retention janitor stop.", a.cfg.Name)
+			return
+		case <-time.After(JANITOR_INTERVAL):
+		}
+	}
+}
+
+func retentionEnabled(r config.ConfigRetention) bool {
+	return r.MaxAgeDays > 0 || r.MaxBytes > 0 || r.MinFreeBytes > 0
+}
+
+func (a *Alarm) enforceRetention(minFree int64) {
+	EnforceRetention(a.cfg, a.currentOutput(), minFree)
+}
+
+// EnforceRetention removes the oldest recordings of cfg until its retention
+// limits hold and minFree bytes are free. The recording being written to
+// current, recordings still being remuxed and held files are skipped.
+func EnforceRetention(cfg *config.ConfigCamera, current string, minFree int64) {
+	r := cfg.Retention
+	groups, total := retentionGroups(cfg, current)
+
+	del := func(g *retentionGroup, reason string) {
+		for _, f := range g.files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				log.Errorf("[%s] Retention failed to remove %s - %v", cfg.Name, f, err)
+			}
+		}
+		total -= g.size
+		log.Infof("[%s] Retention removed %s (%s).", cfg.Name, filepath.Base(g.files[0]), reason)
+	}
+
+	if r.MaxAgeDays > 0 {
+		limit := time.Now().AddDate(0, 0, -r.MaxAgeDays)
+		kept := groups[:0]
+		for _, g := range groups {
+			if g.modTime.Before(limit) {
+				del(g, "max age")
+			} else {
+				kept = append(kept, g)
+			}
+		}
+		groups = kept
+	}
+
+	for r.MaxBytes > 0 && total > r.MaxBytes && len(groups) > 0 {
+		del(groups[0], "max bytes")
+		groups = groups[1:]
+	}
+
+	for minFree > 0 && len(groups) > 0 {
+		free, err := diskFree(cfg.RecPath)
+		if err != nil {
+			log.Errorf("[%s] %v", cfg.Name, err)
+			break
+		}
+		if free >= uint64(minFree) {
+			break
+		}
+		del(groups[0], "min free space")
+		groups = groups[1:]
+	}
+}
+
+func retentionGroups(cfg *config.ConfigCamera, current string) ([]*retentionGroup, int64) {
+	var archives, clips []*retentionGroup
+	var total int64
+
+	if current != "" {
+		current = strings.TrimSuffix(filepath.Base(current), ".ts")
+	}
+
+	collect := func(dir, prefix string, archive bool) []*retentionGroup {
+		byBase := make(map[string]*retentionGroup)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil
+		}
+		for _, e := range entries {
+			name := e.Name()
+			ext := filepath.Ext(name)
+			if strings.HasSuffix(name, REMUX_TEMP_SUFFIX) {
+				ext = REMUX_TEMP_SUFFIX
+			}
+			if e.IsDir() || !strings.HasPrefix(name, prefix) || (ext != ".ts" && ext != ".mp4" && ext != ".json" && ext != REMUX_TEMP_SUFFIX) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			total += info.Size()
+			base := strings.TrimSuffix(name, ext)
+			if !archive && base == current {
+				continue
+			}
+			g := byBase[base]
+			if g == nil {
+				g = &retentionGroup{archive: archive}
+				byBase[base] = g
+			}
+			path := filepath.Join(dir, name)
+			if ext == REMUX_TEMP_SUFFIX && time.Since(info.ModTime()) < REMUX_STALE || isHeld(path) {
+				g.busy = true
+			}
+			g.files = append(g.files, path)
+			g.size += info.Size()
+			if ext != REMUX_TEMP_SUFFIX && info.ModTime().After(g.modTime) {
+				g.modTime = info.ModTime()
+			}
+		}
+		list := make([]*retentionGroup, 0, len(byBase))
+		for _, g := range byBase {
+			if g.busy {
+				continue
+			}
+			sort.Strings(g.files)
+			list = append(list, g)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].modTime.Before(list[j].modTime)
+		})
+		return list
+	}
+
+	archives = collect(filepath.Join(cfg.RecPath, "archive"), cfg.Name+"_", true)
+	clips = collect(cfg.RecPath, "rec_"+cfg.Name+"_", false)
+
+	return append(archives, clips...), total
+}
+
+func (a *Alarm) currentOutput() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.recFile == nil {
+		return ""
+	}
+	return a.currOut
+}
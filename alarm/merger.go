@@ -3,6 +3,7 @@ package alarm
 import (
 	"bv-streamer/config"
 	"bv-streamer/log"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -102,7 +103,10 @@ func merge(entries []os.DirEntry, cfg *config.ConfigCamera) []os.DirEntry {
 	case 1:
 		src := filepath.Join(cfg.RecPath, merges[0])
 		if err := cp(src, outPath); err != nil {
+			metricMergeFailures.Inc(cfg.Name)
 			log.Errorf("[%s] Copy error: %v", cfg.Name, err)
+		} else {
+			mergeMeta(merges, outPath, cfg)
 		}
 	default:
 		lpath := filepath.Join(cfg.RecPath, "merges.txt")
@@ -114,9 +118,11 @@ func merge(entries []os.DirEntry, cfg *config.ConfigCamera) []os.DirEntry {
 			lfile.Close()
 			cmd := exec.Command(cfg.FFmpegPath, "-f", "concat", "-safe", "0", "-i", lpath, "-c", "copy", outPath)
 			if err := cmd.Run(); err != nil {
+				metricMergeFailures.Inc(cfg.Name)
 				log.Errorf("[%s] Failed to create archive. %v", cfg.Name, err)
 			} else {
 				log.Infof("[%s] Archive created for day: %s", cfg.Name, curr.Format(DATE_FORMAT))
+				mergeMeta(merges, outPath, cfg)
 				for _, fname := range merges {
 					if err := os.Remove(filepath.Join(cfg.RecPath, fname)); err != nil {
 						log.Errorf("[%s] Failed to remove file %s - %v", cfg.Name, fname, err)
@@ -125,6 +131,10 @@ func merge(entries []os.DirEntry, cfg *config.ConfigCamera) []os.DirEntry {
 					if err := os.Remove(mp4File); err != nil {
 						log.Errorf("[%s] Failed to remove file %s - %v", cfg.Name, mp4File, err)
 					}
+					metaFile := filepath.Join(cfg.RecPath, strings.TrimSuffix(fname, ".ts")+".json")
+					if err := os.Remove(metaFile); err != nil && !os.IsNotExist(err) {
+						log.Errorf("[%s] Failed to remove file %s - %v", cfg.Name, metaFile, err)
+					}
 				}
 			}
 		}
@@ -134,6 +144,34 @@ func merge(entries []os.DirEntry, cfg *config.ConfigCamera) []os.DirEntry {
 	return filtred
 }
 
+func mergeMeta(merges []string, outPath string, cfg *config.ConfigCamera) {
+	var metas []RecMetaEnvelope
+
+	for _, fname := range merges {
+		data, err := os.ReadFile(filepath.Join(cfg.RecPath, strings.TrimSuffix(fname, ".ts")+".json"))
+		if err != nil {
+			continue
+		}
+		var meta RecMetaEnvelope
+		if err := json.Unmarshal(data, &meta); err != nil {
+			log.Errorf("[%s] Invalid recording metadata %s - %v", cfg.Name, fname, err)
+			continue
+		}
+		metas = append(metas, meta)
+	}
+
+	if len(metas) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(metas, "", "  ")
+	if err == nil {
+		err = os.WriteFile(strings.TrimSuffix(outPath, ".mp4")+".json", data, 0644)
+	}
+	if err != nil {
+		log.Errorf("[%s] Failed to write archive metadata - %v", cfg.Name, err)
+	}
+}
+
 func cp(src, dst string) error {
 	from, err := os.Open(src)
 	if err != nil {
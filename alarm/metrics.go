@@ -0,0 +1,18 @@
+package alarm
+
+import "bv-streamer/metrics"
+
+var (
+	metricPollSeconds = metrics.NewHistogram("bv_event_poll_seconds", "Latency of motion and AI polls against the event source.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}, "camera", "kind")
+	metricPollErrors  = metrics.NewCounter("bv_event_poll_errors_total", "Failed motion and AI polls.", "camera", "kind")
+	metricTransitions = metrics.NewCounter("bv_alarm_transitions_total", "Alarm state transitions by target state.", "camera", "state")
+	metricRecSeconds  = metrics.NewHistogram("bv_recording_duration_seconds", "Duration of finished recordings.",
+		[]float64{10, 30, 60, 120, 300, 600, 1800, 3600}, "camera")
+	metricRecBytes = metrics.NewHistogram("bv_recording_bytes", "Size of finished recordings.",
+		[]float64{1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30}, "camera")
+	metricRemuxFailures = metrics.NewCounter("bv_remux_failures_total", "Failed remuxes of recordings to mp4.", "camera")
+	metricMergeFailures = metrics.NewCounter("bv_merge_failures_total", "Failed daily archive merges.", "camera")
+	metricFreeBytes     = metrics.NewGauge("bv_rec_free_bytes", "Free space on the recording path.", "camera")
+	metricRecDropped    = metrics.NewCounter("bv_recording_dropped_chunks_total", "Recording chunks dropped because the disk could not keep up.", "camera")
+)
@@ -0,0 +1,336 @@
+package alarm
+
+import (
+	"bv-streamer/config"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	onvifStateItems = map[string]bool{
+		"state": true, "ismotion": true, "ispeople": true, "isvehicle": true,
+		"isdogcat": true, "ispet": true, "isface": true, "isobject": true,
+	}
+	onvifClassWords = map[string]Class{
+		"people": CLASS_PEOPLE, "person": CLASS_PEOPLE, "human": CLASS_PEOPLE, "pedestrian": CLASS_PEOPLE,
+		"vehicle": CLASS_VEHICLE, "car": CLASS_VEHICLE, "motorvehicle": CLASS_VEHICLE,
+		"dogcat": CLASS_DOG_CAT, "dog": CLASS_DOG_CAT, "cat": CLASS_DOG_CAT, "animal": CLASS_DOG_CAT, "pet": CLASS_DOG_CAT,
+		"face": CLASS_FACE,
+	}
+)
+
+const (
+	ONVIF_TERMINATION  = 60 * time.Second
+	ONVIF_PULL_TIMEOUT = 1 * time.Second
+	ONVIF_MSG_LIMIT    = 32
+
+	onvifActionCreate      = "http://www.onvif.org/ver10/events/wsdl/EventPortType/CreatePullPointSubscriptionRequest"
+	onvifActionPull        = "http://www.onvif.org/ver10/events/wsdl/PullPointSubscription/PullMessagesRequest"
+	onvifActionRenew       = "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/RenewRequest"
+	onvifActionUnsubscribe = "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/UnsubscribeRequest"
+)
+
+type OnvifSource struct {
+	cfg      *config.ConfigCamera
+	client   *http.Client
+	endpoint string
+
+	mu        sync.Mutex
+	pullPoint string
+	expires   time.Time
+	motion    bool
+	classes   map[Class]bool
+}
+
+func NewOnvifSource(cfg *config.ConfigCamera) *OnvifSource {
+	endpoint := cfg.OnvifURL
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("http://%s/onvif/event_service", cfg.Address)
+	}
+	return &OnvifSource{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: ONVIF_PULL_TIMEOUT + 10*time.Second},
+		endpoint: endpoint,
+		classes:  make(map[Class]bool),
+	}
+}
+
+func (o *OnvifSource) Motion() (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.poll(); err != nil {
+		return false, err
+	}
+	return o.motion, nil
+}
+
+func (o *OnvifSource) Objects() (Detection, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	d := Detection{Time: time.Now()}
+	if err := o.poll(); err != nil {
+		return d, err
+	}
+	for _, c := range []Class{CLASS_PEOPLE, CLASS_VEHICLE, CLASS_DOG_CAT, CLASS_FACE} {
+		if o.classes[c] {
+			d.Classes = append(d.Classes, c)
+		}
+	}
+	return d, nil
+}
+
+func (o *OnvifSource) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.pullPoint == "" {
+		return nil
+	}
+	body := `<wsnt:Unsubscribe xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2"/>`
+	_, err := o.call(o.pullPoint, onvifActionUnsubscribe, body)
+	o.pullPoint = ""
+	return err
+}
+
+func (o *OnvifSource) poll() error {
+	if o.pullPoint == "" || time.Now().After(o.expires) {
+		if err := o.subscribe(); err != nil {
+			return err
+		}
+	} else if time.Until(o.expires) < ONVIF_TERMINATION/2 {
+		if err := o.renew(); err != nil {
+			o.pullPoint = ""
+			return err
+		}
+	}
+
+	body := fmt.Sprintf(`<tev:PullMessages xmlns:tev="http://www.onvif.org/ver10/events/wsdl"><tev:Timeout>%s</tev:Timeout><tev:MessageLimit>%d</tev:MessageLimit></tev:PullMessages>`,
+		xsdDuration(ONVIF_PULL_TIMEOUT), ONVIF_MSG_LIMIT)
+	env, err := o.call(o.pullPoint, onvifActionPull, body)
+	if err != nil {
+		o.pullPoint = ""
+		return err
+	}
+
+	resp := env.Body.PullMessagesResponse
+	if resp.TerminationTime != "" {
+		o.expires = terminationTime(resp.CurrentTime, resp.TerminationTime)
+	}
+	for _, n := range resp.NotificationMessage {
+		o.apply(n)
+	}
+	return nil
+}
+
+func (o *OnvifSource) subscribe() error {
+	body := fmt.Sprintf(`<tev:CreatePullPointSubscription xmlns:tev="http://www.onvif.org/ver10/events/wsdl"><tev:InitialTerminationTime>%s</tev:InitialTerminationTime></tev:CreatePullPointSubscription>`,
+		xsdDuration(ONVIF_TERMINATION))
+	env, err := o.call(o.endpoint, onvifActionCreate, body)
+	if err != nil {
+		return err
+	}
+
+	resp := env.Body.CreatePullPointSubscriptionResponse
+	addr := strings.TrimSpace(resp.SubscriptionReference.Address)
+	if addr == "" {
+		return fmt.Errorf("onvif: subscription response without address")
+	}
+	o.pullPoint = addr
+	o.expires = terminationTime(resp.CurrentTime, resp.TerminationTime)
+	o.motion = false
+	o.classes = make(map[Class]bool)
+	return nil
+}
+
+func (o *OnvifSource) renew() error {
+	body := fmt.Sprintf(`<wsnt:Renew xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2"><wsnt:TerminationTime>%s</wsnt:TerminationTime></wsnt:Renew>`,
+		xsdDuration(ONVIF_TERMINATION))
+	env, err := o.call(o.pullPoint, onvifActionRenew, body)
+	if err != nil {
+		return err
+	}
+	o.expires = terminationTime(env.Body.RenewResponse.CurrentTime, env.Body.RenewResponse.TerminationTime)
+	return nil
+}
+
+// terminationTime converts the lifetime the device granted into local time,
+// so a camera with a skewed clock or a shorter limit is renewed in time.
+func terminationTime(current, termination string) time.Time {
+	now := time.Now()
+	c, err1 := time.Parse(time.RFC3339, strings.TrimSpace(current))
+	t, err2 := time.Parse(time.RFC3339, strings.TrimSpace(termination))
+	if err1 != nil || err2 != nil {
+		return now.Add(ONVIF_TERMINATION)
+	}
+	return now.Add(t.Sub(c))
+}
+
+func (o *OnvifSource) apply(n OnvifNotification) {
+	topic := strings.ToLower(strings.TrimSpace(n.Topic))
+	msg := n.Message.Message
+
+	state, found := true, false
+	for _, item := range msg.Data.SimpleItem {
+		if !onvifStateItems[strings.ToLower(item.Name)] {
+			continue
+		}
+		if v, err := strconv.ParseBool(item.Value); err == nil {
+			state, found = v, true
+			break
+		}
+	}
+	if !found && strings.EqualFold(msg.PropertyOperation, "Deleted") {
+		state = false
+	}
+
+	if strings.Contains(topic, "cellmotiondetector/motion") || strings.Contains(topic, "videosource/motionalarm") {
+		o.motion = state
+		return
+	}
+
+	if !strings.Contains(topic, "ruleengine/") {
+		return
+	}
+
+	segment := strings.TrimSpace(n.Topic)
+	classes := topicClasses(segment[strings.LastIndex(segment, "/")+1:])
+	if len(classes) == 0 {
+		for _, item := range msg.Data.SimpleItem {
+			switch strings.ToLower(item.Name) {
+			case "classtypes", "objecttype", "class", "type":
+				classes = append(classes, topicClasses(item.Value)...)
+			}
+		}
+	}
+	for _, c := range classes {
+		o.classes[c] = state
+	}
+}
+
+// topicClasses maps the words of a topic segment or class list, such as
+// "PeopleDetect" or "Human, Vehicle", to object classes.
+func topicClasses(s string) []Class {
+	var classes []Class
+	seen := make(map[Class]bool)
+	for _, word := range topicWords(s) {
+		if c, ok := onvifClassWords[word]; ok && !seen[c] {
+			seen[c] = true
+			classes = append(classes, c)
+		}
+	}
+	return classes
+}
+
+// topicWords splits s at non-letters and camel case boundaries and returns
+// the lower case words, e.g. "IVSPersonDetect" gives ivs, person, detect.
+func topicWords(s string) []string {
+	var words []string
+	r := []rune(s)
+	start := -1
+	for i, c := range r {
+		if !unicode.IsLetter(c) {
+			if start >= 0 {
+				words = append(words, strings.ToLower(string(r[start:i])))
+				start = -1
+			}
+			continue
+		}
+		if start >= 0 && unicode.IsUpper(c) &&
+			(unicode.IsLower(r[i-1]) || i+1 < len(r) && unicode.IsLower(r[i+1]) && unicode.IsUpper(r[i-1])) {
+			words = append(words, strings.ToLower(string(r[start:i])))
+			start = -1
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, strings.ToLower(string(r[start:])))
+	}
+	return words
+}
+
+func (o *OnvifSource) call(to, action, body string) (*OnvifEnvelope, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	buf.WriteString(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://www.w3.org/2005/08/addressing">`)
+	buf.WriteString(`<s:Header>`)
+	fmt.Fprintf(&buf, `<wsa:Action>%s</wsa:Action><wsa:To>%s</wsa:To>`, action, xmlEscape(to))
+	if o.cfg.User != "" {
+		buf.WriteString(o.security())
+	}
+	buf.WriteString(`</s:Header><s:Body>`)
+	buf.WriteString(body)
+	buf.WriteString(`</s:Body></s:Envelope>`)
+
+	req, err := http.NewRequest(http.MethodPost, to, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", fmt.Sprintf(`application/soap+xml; charset=utf-8; action="%s"`, action))
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var env OnvifEnvelope
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("onvif: %s: %v", resp.Status, err)
+	}
+	if env.Body.Fault != nil {
+		return nil, fmt.Errorf("onvif fault: %s %s", env.Body.Fault.Code.Value, strings.TrimSpace(env.Body.Fault.Reason.Text))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("onvif: unexpected status %s", resp.Status)
+	}
+	return &env, nil
+}
+
+func (o *OnvifSource) security() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(o.cfg.Password))
+	digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return fmt.Sprintf(`<wsse:Security s:mustUnderstand="1" xmlns:wsse="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd" xmlns:wsu="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">`+
+		`<wsse:UsernameToken><wsse:Username>%s</wsse:Username>`+
+		`<wsse:Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">%s</wsse:Password>`+
+		`<wsse:Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">%s</wsse:Nonce>`+
+		`<wsu:Created>%s</wsu:Created></wsse:UsernameToken></wsse:Security>`,
+		xmlEscape(o.cfg.User), digest, base64.StdEncoding.EncodeToString(nonce), created)
+}
+
+func xsdDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%dS", int(d.Seconds()))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package alarm
+
+type OnvifEnvelope struct {
+	Body struct {
+		Fault *struct {
+			Code struct {
+				Value string `xml:"Value"`
+			} `xml:"Code"`
+			Reason struct {
+				Text string `xml:"Text"`
+			} `xml:"Reason"`
+		} `xml:"Fault"`
+		CreatePullPointSubscriptionResponse struct {
+			SubscriptionReference struct {
+				Address string `xml:"Address"`
+			} `xml:"SubscriptionReference"`
+			CurrentTime     string `xml:"CurrentTime"`
+			TerminationTime string `xml:"TerminationTime"`
+		} `xml:"CreatePullPointSubscriptionResponse"`
+		PullMessagesResponse struct {
+			CurrentTime         string              `xml:"CurrentTime"`
+			TerminationTime     string              `xml:"TerminationTime"`
+			NotificationMessage []OnvifNotification `xml:"NotificationMessage"`
+		} `xml:"PullMessagesResponse"`
+		RenewResponse struct {
+			CurrentTime     string `xml:"CurrentTime"`
+			TerminationTime string `xml:"TerminationTime"`
+		} `xml:"RenewResponse"`
+	} `xml:"Body"`
+}
+
+type OnvifNotification struct {
+	Topic   string `xml:"Topic"`
+	Message struct {
+		Message struct {
+			UtcTime           string `xml:"UtcTime,attr"`
+			PropertyOperation string `xml:"PropertyOperation,attr"`
+			Source            struct {
+				SimpleItem []OnvifSimpleItem `xml:"SimpleItem"`
+			} `xml:"Source"`
+			Data struct {
+				SimpleItem []OnvifSimpleItem `xml:"SimpleItem"`
+			} `xml:"Data"`
+		} `xml:"Message"`
+	} `xml:"Message"`
+}
+
+type OnvifSimpleItem struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:"Value,attr"`
+}
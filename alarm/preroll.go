@@ -0,0 +1,96 @@
+package alarm
+
+import (
+	"bv-streamer/ts"
+	"sync"
+	"time"
+)
+
+const (
+	PREROLL_MAX_BYTES = 32 << 20
+)
+
+type gop struct {
+	start time.Time
+	data  []byte
+}
+
+type PreRoll struct {
+	name     string
+	duration time.Duration
+
+	mu      sync.Mutex
+	demuxer *ts.Demuxer
+	gops    []*gop
+	size    int
+	sink    *Recorder
+}
+
+func NewPreRoll(name string, duration time.Duration) *PreRoll {
+	return &PreRoll{
+		name:     name,
+		duration: duration,
+		demuxer:  ts.NewDemuxer(),
+	}
+}
+
+func (p *PreRoll) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.demuxer.Write(b, p.emit)
+	p.trim()
+
+	return len(b), nil
+}
+
+func (p *PreRoll) Attach(r *Recorder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if header := p.demuxer.Header(); header != nil {
+		r.write(header, true)
+	}
+	for _, g := range p.gops {
+		r.write(g.data, true)
+	}
+	p.sink = r
+}
+
+func (p *PreRoll) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.demuxer.Reset()
+	p.gops = nil
+	p.size = 0
+}
+
+func (p *PreRoll) Detach() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sink = nil
+}
+
+func (p *PreRoll) emit(c *ts.Chunk) {
+	data := c.Data
+	if c.Keyframe {
+		p.gops = append(p.gops, &gop{start: time.Now()})
+	}
+	if len(p.gops) > 0 {
+		g := p.gops[len(p.gops)-1]
+		g.data = append(g.data, data...)
+		p.size += len(data)
+	}
+
+	if p.sink != nil {
+		p.sink.write(data, c.Keyframe)
+	}
+}
+
+func (p *PreRoll) trim() {
+	limit := time.Now().Add(-p.duration)
+	for len(p.gops) > 1 && (p.gops[1].start.Before(limit) || p.size > PREROLL_MAX_BYTES) {
+		p.size -= len(p.gops[0].data)
+		p.gops = p.gops[1:]
+	}
+}
@@ -0,0 +1,9 @@
+package alarm
+
+type RecMetaEnvelope struct {
+	Camera  string  `json:"camera"`
+	File    string  `json:"file"`
+	Start   int64   `json:"start"`
+	End     int64   `json:"end"`
+	Classes []Class `json:"classes"`
+}
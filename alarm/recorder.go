@@ -0,0 +1,95 @@
+package alarm
+
+import (
+	"bv-streamer/log"
+	"io"
+	"sync"
+)
+
+const (
+	RECORDER_QUEUE = 512
+)
+
+// Recorder writes recording chunks from its own goroutine, so a slow disk
+// never stalls the shared ingest loop. When the queue is full it drops
+// chunks up to the next keyframe and counts them.
+type Recorder struct {
+	name  string
+	w     io.Writer
+	queue chan []byte
+	done  chan struct{}
+
+	mu       sync.Mutex
+	dropping bool
+	dropped  int
+	closed   bool
+	err      error
+}
+
+func NewRecorder(name string, w io.Writer) *Recorder {
+	r := &Recorder{
+		name:  name,
+		w:     w,
+		queue: make(chan []byte, RECORDER_QUEUE),
+		done:  make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *Recorder) write(data []byte, keyframe bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if r.dropping {
+		if !keyframe {
+			r.dropped++
+			metricRecDropped.Inc(r.name)
+			return
+		}
+		log.Warnf("[%s] Recording resumed at keyframe, %d chunks dropped so far.", r.name, r.dropped)
+		r.dropping = false
+	}
+
+	select {
+	case r.queue <- append([]byte(nil), data...):
+	default:
+		log.Warnf("[%s] Recording disk too slow, dropping to next keyframe.", r.name)
+		r.dropping = true
+		r.dropped++
+		metricRecDropped.Inc(r.name)
+	}
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	for data := range r.queue {
+		if r.err != nil {
+			continue
+		}
+		if _, err := r.w.Write(data); err != nil {
+			log.Errorf("[%s] Recording write error: %v", r.name, err)
+			r.err = err
+		}
+	}
+}
+
+func (r *Recorder) Dropped() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// Close waits until the queued chunks are written.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	<-r.done
+	return r.err
+}
@@ -0,0 +1,86 @@
+package alarm
+
+import (
+	"bv-streamer/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type ReolinkSource struct {
+	cfg    *config.ConfigCamera
+	client *http.Client
+}
+
+func NewReolinkSource(cfg *config.ConfigCamera) *ReolinkSource {
+	return &ReolinkSource{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (r *ReolinkSource) Motion() (bool, error) {
+	var states MdStateEnvelope
+	if err := r.query("GetMdState", &states); err != nil {
+		return false, err
+	}
+	if len(states) > 0 {
+		return states[0].Value.State == 1, nil
+	}
+	return false, nil
+}
+
+func (r *ReolinkSource) Objects() (Detection, error) {
+	d := Detection{Time: time.Now()}
+	var states AiStateEnvelope
+	if err := r.query("GetAiState", &states); err != nil {
+		return d, err
+	}
+	if len(states) > 0 {
+		v := states[0].Value
+		if v.People.AlarmState == 1 {
+			d.Classes = append(d.Classes, CLASS_PEOPLE)
+		}
+		if v.Vehicle.AlarmState == 1 {
+			d.Classes = append(d.Classes, CLASS_VEHICLE)
+		}
+		if v.DogCat.AlarmState == 1 {
+			d.Classes = append(d.Classes, CLASS_DOG_CAT)
+		}
+		if v.Face.AlarmState == 1 {
+			d.Classes = append(d.Classes, CLASS_FACE)
+		}
+	}
+	return d, nil
+}
+
+func (r *ReolinkSource) Close() error {
+	return nil
+}
+
+func (r *ReolinkSource) query(cmd string, v any) error {
+	q := url.Values{}
+	q.Set("cmd", cmd)
+	q.Set("channel", "0")
+	q.Set("user", r.cfg.User)
+	q.Set("password", r.cfg.Password)
+
+	resp, err := r.client.Get(fmt.Sprintf("http://%s/api.cgi?%s", r.cfg.Address, q.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", cmd, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,15 @@
+package alarm
+
+import "time"
+
+type AlarmStatus struct {
+	Armed       bool      `json:"armed"`
+	State       string    `json:"state"`
+	Forced      bool      `json:"forced"`
+	Source      string    `json:"event_source"`
+	LastMotion  time.Time `json:"last_motion"`
+	LastAIAlarm time.Time `json:"last_ai_alarm"`
+	AlarmStart  time.Time `json:"alarm_start"`
+	Recording   string    `json:"recording,omitempty"`
+	Classes     []Class   `json:"classes,omitempty"`
+}
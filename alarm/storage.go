@@ -0,0 +1,91 @@
+package alarm
+
+import (
+	"bv-streamer/events"
+	"bv-streamer/log"
+	"time"
+)
+
+const (
+	STORAGE_CHECK_INTERVAL = 10 * time.Second
+	STORAGE_WARN_DEFAULT   = 256 << 20
+	STORAGE_MIN_DEFAULT    = 64 << 20
+)
+
+type storageState int
+
+const (
+	STORAGE_OK storageState = iota
+	STORAGE_LOW
+	STORAGE_FULL
+)
+
+func (a *Alarm) storageWatchdog() {
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-time.After(STORAGE_CHECK_INTERVAL):
+			if !a.checkStorage() && a.currentOutput() != "" {
+				log.Errorf("[%s] Storage below %d bytes, stopping recording.", a.cfg.Name, a.storageMin)
+				a.stopRec()
+			}
+		}
+	}
+}
+
+func (a *Alarm) checkStorage() bool {
+	a.storageMu.Lock()
+	defer a.storageMu.Unlock()
+
+	free, err := diskFree(a.cfg.RecPath)
+	if err != nil {
+		log.Errorf("[%s] Storage check failed: %v", a.cfg.Name, err)
+		return true
+	}
+	metricFreeBytes.Set(float64(free), a.cfg.Name)
+
+	// Only cameras with a retention block may lose recordings to free space.
+	// Without one, recording is refused below storage_min_bytes until space
+	// is freed by hand; the watchdog keeps checking and resumes on its own.
+	cleanup := retentionEnabled(a.cfg.Retention)
+	if free < uint64(a.storageWarn) && cleanup {
+		log.Warnf("[%s] Storage low: %d bytes free, running emergency cleanup.", a.cfg.Name, free)
+		a.enforceRetention(a.storageWarn)
+		if free, err = diskFree(a.cfg.RecPath); err != nil {
+			return true
+		}
+	}
+
+	state := STORAGE_OK
+	switch {
+	case free < uint64(a.storageMin):
+		state = STORAGE_FULL
+	case free < uint64(a.storageWarn):
+		state = STORAGE_LOW
+	}
+
+	if state != a.storageState {
+		a.storageState = state
+		data := map[string]any{"free_bytes": free, "path": a.cfg.RecPath}
+		switch state {
+		case STORAGE_FULL:
+			log.Errorf("[%s] Storage full: %d bytes free, recordings disabled.", a.cfg.Name, free)
+			if !cleanup {
+				log.Errorf("[%s] No retention configured, nothing is removed automatically. Free space on %s to resume recording.", a.cfg.Name, a.cfg.RecPath)
+			}
+			events.Publish(events.EVENT_STORAGE_FULL, a.cfg.Name, data)
+		case STORAGE_LOW:
+			log.Warnf("[%s] Storage low: %d bytes free.", a.cfg.Name, free)
+			if !cleanup {
+				log.Warnf("[%s] No retention configured, recordings stop below %d bytes free until space is freed.", a.cfg.Name, a.storageMin)
+			}
+			events.Publish(events.EVENT_STORAGE_LOW, a.cfg.Name, data)
+		default:
+			log.Infof("[%s] Storage ok: %d bytes free.", a.cfg.Name, free)
+			events.Publish(events.EVENT_STORAGE_OK, a.cfg.Name, data)
+		}
+	}
+
+	return state != STORAGE_FULL
+}
@@ -0,0 +1,146 @@
+package alarm_test
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func initConfig(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bv-streamer.conf")
+	if err := os.WriteFile(path, []byte(`{"loglevel": "error", "ws_port": 1510}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Init(&path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recDir creates files of the given sizes with the given ages.
+func recDir(t *testing.T, files map[string]struct {
+	size int
+	age  time.Duration
+}) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "archive"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, f.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-f.age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func remaining(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	for _, sub := range []string{"", "archive"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				names = append(names, filepath.Join(sub, e.Name()))
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+type file = struct {
+	size int
+	age  time.Duration
+}
+
+const day = 24 * time.Hour
+
+func TestRetentionMaxAge(t *testing.T) {
+	initConfig(t)
+	dir := recDir(t, map[string]file{
+		"rec_cam_1.ts":                 {10, 10 * day},
+		"rec_cam_1.mp4":                {10, 10 * day},
+		"rec_cam_1.json":               {1, 10 * day},
+		"rec_cam_2.ts":                 {10, 10 * day},
+		"rec_cam_2.mp4.tmp":            {10, time.Second},
+		"rec_cam_3.ts":                 {10, 10 * day},
+		"rec_cam_3.mp4.tmp":            {10, time.Hour},
+		"rec_cam_4.ts":                 {10, 10 * day},
+		"rec_cam_5.ts":                 {10, 10 * day},
+		"rec_cam_6.ts":                 {10, day},
+		"rec_other_1.ts":               {10, 10 * day},
+		"archive/cam_2025-01-01.mp4":   {10, 10 * day},
+		"archive/cam_2025-01-01.json":  {1, 10 * day},
+		"archive/cam_2025-01-08.mp4":   {10, day},
+		"archive/other_2025-01-01.mp4": {10, 10 * day},
+	})
+	cfg := &config.ConfigCamera{Name: "cam", RecPath: dir, Retention: config.ConfigRetention{MaxAgeDays: 7}}
+
+	// rec_cam_4 is being downloaded and rec_cam_5 is being recorded.
+	release := alarm.Hold(filepath.Join(dir, "rec_cam_4.ts"))
+	alarm.EnforceRetention(cfg, filepath.Join(dir, "rec_cam_5.ts"), 0)
+
+	want := []string{
+		"archive/cam_2025-01-08.mp4",
+		"archive/other_2025-01-01.mp4",
+		"rec_cam_2.mp4.tmp",
+		"rec_cam_2.ts",
+		"rec_cam_4.ts",
+		"rec_cam_5.ts",
+		"rec_cam_6.ts",
+		"rec_other_1.ts",
+	}
+	if got := remaining(t, dir); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	release()
+	alarm.EnforceRetention(cfg, "", 0)
+	want = []string{
+		"archive/cam_2025-01-08.mp4",
+		"archive/other_2025-01-01.mp4",
+		"rec_cam_2.mp4.tmp",
+		"rec_cam_2.ts",
+		"rec_cam_6.ts",
+		"rec_other_1.ts",
+	}
+	if got := remaining(t, dir); !slices.Equal(got, want) {
+		t.Fatalf("after release got %v, want %v", got, want)
+	}
+}
+
+func TestRetentionMaxBytes(t *testing.T) {
+	initConfig(t)
+	dir := recDir(t, map[string]file{
+		"archive/cam_2025-01-01.mp4": {100, 5 * day},
+		"archive/cam_2025-01-02.mp4": {100, 4 * day},
+		"rec_cam_1.ts":               {100, 3 * day},
+		"rec_cam_1.mp4":              {100, 3 * day},
+		"rec_cam_2.ts":               {100, 2 * day},
+		"rec_cam_3.ts":               {100, day},
+		"rec_cam_3.mp4.tmp":          {100, time.Second},
+	})
+	// Archives go first, then the oldest clips. The remuxing clip counts
+	// towards the total but is never removed.
+	cfg := &config.ConfigCamera{Name: "cam", RecPath: dir, Retention: config.ConfigRetention{MaxBytes: 250}}
+	alarm.EnforceRetention(cfg, "", 0)
+
+	want := []string{"rec_cam_3.mp4.tmp", "rec_cam_3.ts"}
+	if got := remaining(t, dir); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
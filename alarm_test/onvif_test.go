@@ -0,0 +1,264 @@
+package alarm_test
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const onvifPullResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tev="http://www.onvif.org/ver10/events/wsdl" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tt="http://www.onvif.org/ver10/schema">
+<env:Body><tev:PullMessagesResponse>
+<tev:CurrentTime>2025-01-01T00:00:00Z</tev:CurrentTime>
+<tev:TerminationTime>%s</tev:TerminationTime>
+%s
+</tev:PullMessagesResponse></env:Body></env:Envelope>`
+
+const onvifNotification = `<wsnt:NotificationMessage>
+<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">%s</wsnt:Topic>
+<wsnt:Message><tt:Message UtcTime="2025-01-01T00:00:00Z" PropertyOperation="Changed">
+<tt:Source><tt:SimpleItem Name="VideoSourceConfigurationToken" Value="00000"/></tt:Source>
+<tt:Data>%s</tt:Data>
+</tt:Message></wsnt:Message>
+</wsnt:NotificationMessage>`
+
+type onvifStandIn struct {
+	mu       sync.Mutex
+	pending  []string
+	actions  []string
+	security bool
+	lifetime time.Duration // granted subscription lifetime
+	fail     int           // number of requests to answer with a fault
+	server   *httptest.Server
+}
+
+func newOnvifStandIn() *onvifStandIn {
+	o := &onvifStandIn{lifetime: time.Minute}
+	o.server = httptest.NewServer(http.HandlerFunc(o.handle))
+	return o
+}
+
+func (o *onvifStandIn) push(topic, name, value string) {
+	o.pushItems(topic, fmt.Sprintf(`<tt:SimpleItem Name="%s" Value="%s"/>`, name, value))
+}
+
+func (o *onvifStandIn) pushItems(topic, items string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending = append(o.pending, fmt.Sprintf(onvifNotification, topic, items))
+}
+
+func (o *onvifStandIn) termination() string {
+	return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(o.lifetime).Format(time.RFC3339)
+}
+
+func (o *onvifStandIn) reset() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	actions := o.actions
+	o.actions = nil
+	return actions
+}
+
+func (o *onvifStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := string(body)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.security = o.security || strings.Contains(req, "PasswordDigest")
+
+	w.Header().Set("Content-Type", "application/soap+xml")
+	switch {
+	case o.fail > 0:
+		o.fail--
+		action := "pull"
+		if strings.Contains(req, "Renew>") {
+			action = "renew"
+		}
+		o.actions = append(o.actions, action+" fault")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><env:Fault><env:Code><env:Value>env:Receiver</env:Value></env:Code><env:Reason><env:Text>unknown subscription</env:Text></env:Reason></env:Fault></env:Body></env:Envelope>`)
+	case strings.Contains(req, "CreatePullPointSubscription>"):
+		o.actions = append(o.actions, "create")
+		fmt.Fprintf(w, `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tev="http://www.onvif.org/ver10/events/wsdl" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:wsa="http://www.w3.org/2005/08/addressing">
+<env:Body><tev:CreatePullPointSubscriptionResponse>
+<tev:SubscriptionReference><wsa:Address>%s/onvif/pullpoint/1</wsa:Address></tev:SubscriptionReference>
+<wsnt:CurrentTime>2025-01-01T00:00:00Z</wsnt:CurrentTime>
+<wsnt:TerminationTime>%s</wsnt:TerminationTime>
+</tev:CreatePullPointSubscriptionResponse></env:Body></env:Envelope>`, o.server.URL, o.termination())
+	case strings.Contains(req, "PullMessages>"):
+		o.actions = append(o.actions, "pull")
+		fmt.Fprintf(w, onvifPullResponse, o.termination(), strings.Join(o.pending, "\n"))
+		o.pending = nil
+	case strings.Contains(req, "Renew>"):
+		o.actions = append(o.actions, "renew")
+		fmt.Fprintf(w, `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2">
+<env:Body><wsnt:RenewResponse>
+<wsnt:TerminationTime>%s</wsnt:TerminationTime>
+<wsnt:CurrentTime>2025-01-01T00:00:00Z</wsnt:CurrentTime>
+</wsnt:RenewResponse></env:Body></env:Envelope>`, o.termination())
+	case strings.Contains(req, "Unsubscribe"):
+		o.actions = append(o.actions, "unsubscribe")
+		fmt.Fprint(w, `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><wsnt:UnsubscribeResponse xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2"/></env:Body></env:Envelope>`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><env:Fault><env:Code><env:Value>env:Sender</env:Value></env:Code><env:Reason><env:Text>unknown action</env:Text></env:Reason></env:Fault></env:Body></env:Envelope>`)
+	}
+}
+
+func Test_onvifPullPoint(t *testing.T) {
+	standIn := newOnvifStandIn()
+	defer standIn.server.Close()
+
+	source := alarm.NewOnvifSource(&config.ConfigCamera{
+		Name:     "onvif",
+		User:     "admin",
+		Password: "secret",
+		OnvifURL: standIn.server.URL + "/onvif/event_service",
+	})
+
+	if motion, err := source.Motion(); err != nil || motion {
+		t.Fatalf("expected no motion, got %v %v", motion, err)
+	}
+
+	standIn.push("tns1:RuleEngine/CellMotionDetector/Motion", "IsMotion", "true")
+	standIn.push("tns1:RuleEngine/MyRuleDetector/PeopleDetect", "State", "true")
+	standIn.push("tns1:RuleEngine/ObjectDetector/Object", "ClassTypes", "Vehicle")
+
+	if motion, err := source.Motion(); err != nil || !motion {
+		t.Fatalf("expected motion, got %v %v", motion, err)
+	}
+	detection, err := source.Objects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !detection.Has(alarm.CLASS_PEOPLE) || !detection.Has(alarm.CLASS_VEHICLE) || detection.Has(alarm.CLASS_FACE) {
+		t.Fatalf("unexpected classes %v", detection.Classes)
+	}
+
+	standIn.push("tns1:RuleEngine/CellMotionDetector/Motion", "IsMotion", "false")
+	standIn.push("tns1:RuleEngine/MyRuleDetector/PeopleDetect", "State", "false")
+	if motion, err := source.Motion(); err != nil || motion {
+		t.Fatalf("expected motion cleared, got %v %v", motion, err)
+	}
+	if detection, _ := source.Objects(); detection.Has(alarm.CLASS_PEOPLE) {
+		t.Fatalf("expected people cleared, got %v", detection.Classes)
+	}
+
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+	if !standIn.security {
+		t.Fatal("expected WS-Security UsernameToken")
+	}
+	if standIn.actions[0] != "create" || standIn.actions[len(standIn.actions)-1] != "unsubscribe" {
+		t.Fatalf("unexpected call sequence %v", standIn.actions)
+	}
+}
+
+func Test_onvifTopicMatching(t *testing.T) {
+	standIn := newOnvifStandIn()
+	defer standIn.server.Close()
+
+	source := alarm.NewOnvifSource(&config.ConfigCamera{
+		Name:     "onvif",
+		OnvifURL: standIn.server.URL + "/onvif/event_service",
+	})
+	defer source.Close()
+
+	// Words that merely contain a class name must not match.
+	standIn.push("tns1:RuleEngine/MyRuleDetector/Notification", "State", "true")
+	standIn.push("tns1:RuleEngine/MyRuleDetector/Location", "State", "true")
+	standIn.push("tns1:RuleEngine/CardReader/Card", "State", "true")
+	standIn.push("tns1:RuleEngine/MyRuleDetector/Competence", "State", "true")
+	if detection, err := source.Objects(); err != nil || len(detection.Classes) != 0 {
+		t.Fatalf("expected no classes, got %v %v", detection.Classes, err)
+	}
+
+	// The state comes from the named item, not the first boolean-like one.
+	standIn.pushItems("tns1:RuleEngine/MyRuleDetector/PeopleDetect",
+		`<tt:SimpleItem Name="Region" Value="1"/><tt:SimpleItem Name="State" Value="false"/>`)
+	standIn.pushItems("tns1:RuleEngine/MyRuleDetector/DogCatDetect",
+		`<tt:SimpleItem Name="Count" Value="0"/><tt:SimpleItem Name="State" Value="true"/>`)
+	standIn.push("tns1:RuleEngine/IVS/IVSPersonDetect", "IsPeople", "false")
+	standIn.push("tns1:RuleEngine/ObjectDetector/Object", "ClassTypes", "Human, Car")
+	detection, err := source.Objects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !detection.Has(alarm.CLASS_DOG_CAT) || !detection.Has(alarm.CLASS_VEHICLE) || detection.Has(alarm.CLASS_FACE) {
+		t.Fatalf("unexpected classes %v", detection.Classes)
+	}
+	if !detection.Has(alarm.CLASS_PEOPLE) {
+		t.Fatalf("expected people from the class list, got %v", detection.Classes)
+	}
+}
+
+func Test_onvifRenew(t *testing.T) {
+	standIn := newOnvifStandIn()
+	defer standIn.server.Close()
+
+	source := alarm.NewOnvifSource(&config.ConfigCamera{
+		Name:     "onvif",
+		OnvifURL: standIn.server.URL + "/onvif/event_service",
+	})
+	defer source.Close()
+
+	poll := func(want ...string) {
+		t.Helper()
+		source.Motion()
+		if got := standIn.reset(); !slices.Equal(got, want) {
+			t.Fatalf("got calls %v, want %v", got, want)
+		}
+	}
+
+	// A lifetime under half the requested termination is renewed on every
+	// poll.
+	standIn.lifetime = 20 * time.Second
+	poll("create", "pull")
+	poll("renew", "pull")
+	poll("renew", "pull")
+
+	// A long lifetime is left alone.
+	standIn.lifetime = time.Minute
+	poll("renew", "pull")
+	poll("pull")
+
+	// A failed pull, e.g. after a camera reboot, subscribes again and
+	// clears the stale state.
+	standIn.push("tns1:RuleEngine/CellMotionDetector/Motion", "IsMotion", "true")
+	poll("pull")
+	standIn.fail = 1
+	poll("pull fault")
+	if motion, _ := source.Motion(); motion {
+		t.Fatal("motion survived the resubscribe")
+	}
+	if got := standIn.reset(); !slices.Equal(got, []string{"create", "pull"}) {
+		t.Fatalf("got calls %v after a failed pull", got)
+	}
+
+	// A failed renew subscribes again as well.
+	standIn.lifetime = 20 * time.Second
+	poll("pull")
+	standIn.fail = 1
+	poll("renew fault")
+	poll("create", "pull")
+
+	// An expired subscription is replaced instead of renewed.
+	standIn.lifetime = 0
+	poll("renew", "pull")
+	time.Sleep(10 * time.Millisecond)
+	poll("create", "pull")
+}
@@ -0,0 +1,160 @@
+package alarm_test
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/ts"
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func tsPackets(pid int, payload []byte) []byte {
+	var out []byte
+	for cc := 0; len(payload) > 0; cc++ {
+		pkt := make([]byte, ts.PACKET_SIZE)
+		pkt[0] = ts.SYNC_BYTE
+		pkt[1] = byte(pid>>8) & 0x1f
+		if cc == 0 {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+		if n := len(payload); n >= 184 {
+			pkt[3] = 0x10 | byte(cc&0x0f)
+			copy(pkt[4:], payload[:184])
+			payload = payload[184:]
+		} else {
+			pkt[3] = 0x30 | byte(cc&0x0f)
+			af := 183 - n
+			pkt[4] = byte(af)
+			for i := 5; i < 5+af; i++ {
+				pkt[i] = 0xff
+			}
+			if af > 0 {
+				pkt[5] = 0x00
+			}
+			copy(pkt[5+af:], payload)
+			payload = nil
+		}
+		out = append(out, pkt...)
+	}
+	return out
+}
+
+func frame(i int, keyframe bool) []byte {
+	pts := int64(i) * 3000
+	es := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x41, 0x9a}
+	if keyframe {
+		es = []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x65, 0x88}
+	}
+	es = append(es, bytes.Repeat([]byte{0x22}, 1000)...)
+	pes := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5,
+		byte(0x21 | (pts>>29)&0x0e), byte(pts >> 22), byte(0x01 | (pts>>14)&0xfe), byte(pts >> 7), byte(0x01 | (pts<<1)&0xfe)}
+	return tsPackets(0x100, append(pes, es...))
+}
+
+// stalledDisk blocks every write until released.
+type stalledDisk struct {
+	release chan struct{}
+	buf     bytes.Buffer
+	writes  atomic.Int64
+}
+
+func (d *stalledDisk) Write(b []byte) (int, error) {
+	<-d.release
+	d.writes.Add(1)
+	return d.buf.Write(b)
+}
+
+// drained waits until the recorder stops writing to the released disk.
+func (d *stalledDisk) drained() {
+	for last := int64(-1); ; {
+		time.Sleep(20 * time.Millisecond)
+		if n := d.writes.Load(); n != last {
+			last = n
+			continue
+		}
+		return
+	}
+}
+
+func Test_recorderStalledDisk(t *testing.T) {
+	initConfig(t)
+
+	pat := []byte{0x00, 0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00, 0, 0, 0, 0}
+	pmt := []byte{0x00, 0x02, 0xb0, 18, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x00, ts.STREAM_TYPE_H264, 0xe1, 0x00, 0xf0, 0x00, 0, 0, 0, 0}
+
+	p := alarm.NewPreRoll("test", time.Minute)
+	p.Write(append(tsPackets(0, pat), tsPackets(0x1000, pmt)...))
+	for i := range 10 {
+		p.Write(frame(i, i == 0))
+	}
+
+	disk := &stalledDisk{release: make(chan struct{})}
+	rec := alarm.NewRecorder("test", disk)
+	p.Attach(rec)
+
+	const stalled, total = 1500, 3000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 10; i < stalled; i++ {
+			p.Write(frame(i, i%10 == 0))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(disk.release)
+		t.Fatal("pre-roll writes blocked on the stalled disk")
+	}
+	if rec.Dropped() == 0 {
+		t.Fatal("no chunks dropped while the disk was stalled")
+	}
+
+	// Once the disk catches up the recording resumes at the next keyframe.
+	close(disk.release)
+	disk.drained()
+	for i := stalled; i < total; i++ {
+		p.Write(frame(i, i%10 == 0))
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p.Write(frame(total, true))
+
+	// The recording starts with the pre-roll and every gap resumes at a
+	// keyframe.
+	type seen struct {
+		pts      int64
+		keyframe bool
+	}
+	var frames []seen
+	d := ts.NewDemuxer()
+	d.OnFrame = func(f *ts.Frame) {
+		frames = append(frames, seen{f.PTS, f.Keyframe})
+	}
+	d.Write(disk.buf.Bytes(), func(*ts.Chunk) {})
+	d.Write(frame(total+1, true), func(*ts.Chunk) {})
+
+	if len(frames) < 10 || len(frames) >= total {
+		t.Fatalf("recorded %d frames", len(frames))
+	}
+	for i, f := range frames[:10] {
+		if f.pts != int64(i)*3000 {
+			t.Fatalf("pre-roll frame %d has pts %d", i, f.pts)
+		}
+	}
+	gaps := 0
+	for i := 1; i < len(frames); i++ {
+		if frames[i].pts != frames[i-1].pts+3000 {
+			gaps++
+			if !frames[i].keyframe {
+				t.Fatalf("recording resumes at pts %d without a keyframe", frames[i].pts)
+			}
+		}
+	}
+	if gaps == 0 {
+		t.Fatal("no gap in the recording")
+	}
+}
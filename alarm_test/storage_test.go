@@ -0,0 +1,72 @@
+package alarm_test
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/ingest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// With storage limits far above any real disk the camera is always full.
+func TestStorageFull(t *testing.T) {
+	initConfig(t)
+
+	for _, tt := range []struct {
+		name      string
+		retention config.ConfigRetention
+		want      []string
+	}{
+		{"without retention", config.ConfigRetention{}, []string{"rec_cam_1.json", "rec_cam_1.ts"}},
+		{"with retention", config.ConfigRetention{MaxAgeDays: 3650}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := recDir(t, map[string]file{
+				"rec_cam_1.ts":   {10, day},
+				"rec_cam_1.json": {1, day},
+			})
+			cfg := &config.ConfigCamera{
+				Name:        "cam",
+				FFmpegPath:  "/bin/false",
+				RecPath:     dir,
+				MdInterval:  3600,
+				Retention:   tt.retention,
+				StorageWarn: 1 << 62,
+				StorageMin:  1 << 61,
+			}
+
+			evs, unsubscribe := events.Subscribe(64)
+			defer unsubscribe()
+			src := ingest.NewIngest(cfg)
+			defer src.Close()
+			a := alarm.NewAlarm(cfg, src)
+			go a.Run()
+			defer a.Stop()
+
+			if err := a.ForceStart(); err != nil {
+				t.Fatal(err)
+			}
+			if rec := a.Status().Recording; rec != "" {
+				t.Fatalf("recording %s started on a full disk", rec)
+			}
+			// Recordings are only removed to free space with retention set.
+			if got := remaining(t, dir); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			timeout := time.After(2 * time.Second)
+			for {
+				select {
+				case ev := <-evs:
+					if ev.Type == events.EVENT_STORAGE_FULL && ev.Camera == "cam" {
+						return
+					}
+				case <-timeout:
+					t.Fatal("no storage_full event")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package certs
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const CERT_CHECK_INTERVAL = 10 * time.Second
+
+// Reloader serves a certificate and key pair and picks up renewed files,
+// checking their modification time at most once per interval.
+type Reloader struct {
+	certPath string
+	keyPath  string
+	interval time.Duration
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func NewReloader(certPath, keyPath string, interval time.Duration) (*Reloader, error) {
+	c := &Reloader{certPath: certPath, keyPath: keyPath, interval: interval}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if time.Since(c.checked) >= c.interval {
+		c.checked = time.Now()
+		if mod := c.lastModified(); mod.After(c.modTime) {
+			if err := c.load(); err != nil {
+				log.Printf("TLS certificate reload failed, keeping current: %v", err)
+			} else {
+				log.Println("TLS certificate reloaded.")
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+func (c *Reloader) load() error {
+	mod := c.lastModified()
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = mod
+	c.checked = time.Now()
+	return nil
+}
+
+func (c *Reloader) lastModified() time.Time {
+	var mod time.Time
+	for _, path := range []string{c.certPath, c.keyPath} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(mod) {
+			mod = info.ModTime()
+		}
+	}
+	return mod
+}
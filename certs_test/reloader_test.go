@@ -0,0 +1,122 @@
+package certs_test
+
+import (
+	"bv-streamer/certs"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type certFiles struct {
+	cert, key string
+	modTime   time.Time
+}
+
+// write stores a fresh self-signed certificate for name, stamped a second
+// after the previous one so the change is seen on coarse file systems.
+func (f *certFiles) write(t *testing.T, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.store(t, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func (f *certFiles) store(t *testing.T, cert, key []byte) {
+	t.Helper()
+	if f.modTime.IsZero() {
+		f.modTime = time.Now().Add(-time.Minute)
+	}
+	f.modTime = f.modTime.Add(time.Second)
+	for path, data := range map[string][]byte{f.cert: cert, f.key: key} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, f.modTime, f.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func commonName(t *testing.T, r *certs.Reloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	files := &certFiles{cert: filepath.Join(dir, "cert.pem"), key: filepath.Join(dir, "key.pem")}
+
+	if _, err := certs.NewReloader(files.cert, files.key, 0); err == nil {
+		t.Fatal("expected an error for missing files")
+	}
+
+	files.write(t, "first.example")
+	r, err := certs.NewReloader(files.cert, files.key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := commonName(t, r); name != "first.example" {
+		t.Fatalf("got %s, want first.example", name)
+	}
+
+	files.write(t, "second.example")
+	if name := commonName(t, r); name != "second.example" {
+		t.Fatalf("renewed certificate not picked up, got %s", name)
+	}
+
+	// A broken renewal keeps the current certificate.
+	files.store(t, []byte("not a certificate"), []byte("not a key"))
+	if name := commonName(t, r); name != "second.example" {
+		t.Fatalf("broken renewal replaced the certificate, got %s", name)
+	}
+}
+
+func TestReloaderInterval(t *testing.T) {
+	dir := t.TempDir()
+	files := &certFiles{cert: filepath.Join(dir, "cert.pem"), key: filepath.Join(dir, "key.pem")}
+
+	files.write(t, "first.example")
+	r, err := certs.NewReloader(files.cert, files.key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files.write(t, "second.example")
+	if name := commonName(t, r); name != "first.example" {
+		t.Fatalf("files checked before the interval passed, got %s", name)
+	}
+}
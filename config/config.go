@@ -1,11 +1,12 @@
 package config
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type LogLevel int
@@ -20,11 +21,43 @@ const (
 
 var SigShutdown = make(chan struct{})
 
-var global *ConfigGlobal
+var (
+	mutex  sync.RWMutex
+	global *ConfigGlobal
+)
 
 func Init(path *string) error {
+	g, err := load(path)
+
+	mutex.Lock()
+	global = g
+	mutex.Unlock()
+
+	return err
+}
+
+func Reload(path *string) (*ConfigGlobal, Issues, error) {
+	g, err := load(path)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	global = &ConfigGlobal{
+	issues := g.Validate()
+	if issues.HasErrors() {
+		return nil, issues, fmt.Errorf("config has errors, keeping current config")
+	}
+
+	mutex.Lock()
+	old := global
+	global = g
+	mutex.Unlock()
+
+	return old, issues, nil
+}
+
+func load(path *string) (*ConfigGlobal, error) {
+
+	g := &ConfigGlobal{
 		LoglevelStr: "info",
 		Cameras:     make([]*ConfigCamera, 0),
 	}
@@ -39,12 +72,10 @@ func Init(path *string) error {
 			conf = *path
 		}
 
-		file, err := os.Open(conf)
-		if err == nil {
-			defer file.Close()
-			decoder := json.NewDecoder(file)
-			err = decoder.Decode(&global)
-			if err != nil {
+		var data []byte
+		if data, err = os.ReadFile(conf); err == nil {
+			if err = Unmarshal(data, g); err != nil {
+				err = fmt.Errorf("%s: %w", conf, err)
 				log.Printf("%v - Error decoding config file.\n", err)
 			}
 		} else {
@@ -58,32 +89,34 @@ func Init(path *string) error {
 		log.Println("Config loaded ok.")
 	}
 
-	switch strings.ToLower(global.LoglevelStr) {
+	switch strings.ToLower(g.LoglevelStr) {
 	case "debug":
-		global.LogLevel = LOG_DEBUG
+		g.LogLevel = LOG_DEBUG
 	case "info":
-		global.LogLevel = LOG_INFO
+		g.LogLevel = LOG_INFO
 	case "warn":
-		global.LogLevel = LOG_WARN
+		g.LogLevel = LOG_WARN
 	case "error":
-		global.LogLevel = LOG_ERROR
+		g.LogLevel = LOG_ERROR
 	case "verb":
-		global.LogLevel = LOG_VERB
+		g.LogLevel = LOG_VERB
 	default:
-		global.LogLevel = LOG_INFO
+		g.LogLevel = LOG_INFO
 	}
 
-	return err
+	return g, err
 
 }
 
 func GetConfigGlobal() *ConfigGlobal {
+	mutex.RLock()
+	defer mutex.RUnlock()
 	return global
 }
 
 func GetCamera(name string) *ConfigCamera {
 	name = strings.ToLower(name)
-	for _, cam := range global.Cameras {
+	for _, cam := range GetConfigGlobal().Cameras {
 		if strings.ToLower(cam.Name) == name {
 			return cam
 		}
@@ -93,5 +126,5 @@ func GetCamera(name string) *ConfigCamera {
 }
 
 func GetCameras() []*ConfigCamera {
-	return global.Cameras
+	return GetConfigGlobal().Cameras
 }
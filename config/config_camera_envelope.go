@@ -1,19 +1,39 @@
 package config
 
 type ConfigCamera struct {
-	Name         string   `json:"name"`
-	RTSPURL      string   `json:"rtsp_url"`
-	WSPath       string   `json:"ws_path"`
-	Origins      []string `json:"origins"`
-	FFmpegPath   string   `json:"ffmpeg_path"`
-	FFMpegParams []string `json:"ffmpeg_params"`
-	Address      string   `json:"addr"`
-	User         string   `json:"user"`
-	Password     string   `json:"pass"`
-	Tracking     bool     `json:"tracking"`
-	RecPath      string   `json:"rec_path"`
-	MdInterval   int      `json:"md_interval"`
-	AiInterval   int      `json:"ai_interval"`
-	AiCooldown   int      `json:"ai_cooldown"`
-	ReCooldown   int      `json:"rec_cooldown"`
+	Name         string          `json:"name"`
+	RTSPURL      string          `json:"rtsp_url"`
+	WSPath       string          `json:"ws_path"`
+	WSFormat     string          `json:"ws_format"`
+	AuthTokens   []string        `json:"auth_tokens"`
+	AuthSecret   string          `json:"auth_secret"`
+	Origins      []string        `json:"origins"`
+	FFmpegPath   string          `json:"ffmpeg_path"`
+	FFMpegParams []string        `json:"ffmpeg_params"`
+	Address      string          `json:"addr"`
+	User         string          `json:"user"`
+	Password     string          `json:"pass"`
+	Tracking     bool            `json:"tracking"`
+	EventSource  string          `json:"event_source"`
+	OnvifURL     string          `json:"onvif_url"`
+	RecPath      string          `json:"rec_path"`
+	MdInterval   int             `json:"md_interval"`
+	AiInterval   int             `json:"ai_interval"`
+	AiCooldown   int             `json:"ai_cooldown"`
+	AiTriggers   []string        `json:"ai_triggers"`
+	ReCooldown   int             `json:"rec_cooldown"`
+	PreRoll      int             `json:"pre_roll"`
+	Retention    ConfigRetention `json:"retention"`
+	StorageWarn  int64           `json:"storage_warn_bytes"`
+	StorageMin   int64           `json:"storage_min_bytes"`
+	ClientQueue  int             `json:"client_queue"`
+	SlowClient   string          `json:"slow_client"`
+	WriteTimeout int             `json:"write_timeout"`
+
+	HLS           bool    `json:"hls"`
+	HLSLowLatency bool    `json:"hls_ll"`
+	HLSSegment    float64 `json:"hls_segment"`
+	HLSPart       float64 `json:"hls_part"`
+	HLSWindow     int     `json:"hls_window"`
+	HLSDir        string  `json:"hls_dir"`
 }
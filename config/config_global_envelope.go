@@ -1,9 +1,30 @@
 package config
 
 type ConfigGlobal struct {
-	LogLevel    LogLevel
-	LoglevelStr string          `json:"loglevel"`
-	WShost      string          `json:"ws_host"`
-	WSPort      int             `json:"ws_port"`
-	Cameras     []*ConfigCamera `json:"cameras"`
+	LogLevel        LogLevel
+	LoglevelStr     string           `json:"loglevel"`
+	WShost          string           `json:"ws_host"`
+	WSPort          int              `json:"ws_port"`
+	RecAPI          bool             `json:"recordings_api"`
+	ReloadAPI       bool             `json:"reload_api"`
+	MetricsAPI      bool             `json:"metrics_api"`
+	EventsAPI       bool             `json:"events_api"`
+	ShutdownTimeout int              `json:"shutdown_timeout"`
+	TLSCert         string           `json:"tls_cert"`
+	TLSKey          string           `json:"tls_key"`
+	HTTPPort        int              `json:"http_port"`
+	HTTPRedirect    bool             `json:"http_redirect"`
+	AuthTokens      []string         `json:"auth_tokens"`
+	AuthSecret      string           `json:"auth_secret"`
+	Origins         []string         `json:"origins"`
+	AdminHost       string           `json:"admin_host"`
+	AdminPort       int              `json:"admin_port"`
+	AdminToken      string           `json:"admin_token"`
+	MQTT            *ConfigMQTT      `json:"mqtt"`
+	PublicURL       string           `json:"public_url"`
+	Webhooks        []*ConfigWebhook `json:"webhooks"`
+	WebhookQueue    string           `json:"webhook_queue"`
+	WebhookMaxAge   int              `json:"webhook_max_age"`
+	WebhookRetry    int              `json:"webhook_retry"`
+	Cameras         []*ConfigCamera  `json:"cameras"`
 }
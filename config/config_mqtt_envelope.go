@@ -0,0 +1,15 @@
+package config
+
+type ConfigMQTT struct {
+	Broker          string `json:"broker"`
+	ClientID        string `json:"client_id"`
+	User            string `json:"user"`
+	Pass            string `json:"pass"`
+	QoS             byte   `json:"qos"`
+	KeepAlive       int    `json:"keepalive"`
+	TopicPrefix     string `json:"topic_prefix"`
+	Discovery       bool   `json:"discovery"`
+	DiscoveryPrefix string `json:"discovery_prefix"`
+	TLSCA           string `json:"tls_ca"`
+	TLSInsecure     bool   `json:"tls_insecure"`
+}
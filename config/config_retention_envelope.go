@@ -0,0 +1,7 @@
+package config
+
+type ConfigRetention struct {
+	MaxAgeDays   int   `json:"max_age_days"`
+	MaxBytes     int64 `json:"max_bytes"`
+	MinFreeBytes int64 `json:"min_free_bytes"`
+}
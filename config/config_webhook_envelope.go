@@ -0,0 +1,14 @@
+package config
+
+type ConfigWebhook struct {
+	Name     string            `json:"name"`
+	URL      string            `json:"url"`
+	Type     string            `json:"type"`
+	Method   string            `json:"method"`
+	Token    string            `json:"token"`
+	Headers  map[string]string `json:"headers"`
+	Template string            `json:"template"`
+	Events   []string          `json:"events"`
+	Cameras  []string          `json:"cameras"`
+	Timeout  int               `json:"timeout"`
+}
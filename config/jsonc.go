@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+func Unmarshal(data []byte, v any) error {
+	std, err := standardize(data)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(std))
+	if err = decoder.Decode(v); err != nil {
+		var syntax *json.SyntaxError
+		var typ *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntax):
+			return newSyntaxError(data, int(syntax.Offset), syntax.Error())
+		case errors.As(err, &typ):
+			return newSyntaxError(data, int(typ.Offset), typ.Error())
+		}
+		return err
+	}
+	if decoder.More() {
+		return newSyntaxError(data, int(decoder.InputOffset()), "unexpected data after top-level value")
+	}
+	return nil
+}
+
+// Blanks out comments and trailing commas so offsets still match the source.
+// Only a comma that follows a value may be blanked, so "[,]" stays an error.
+func standardize(data []byte) ([]byte, error) {
+	out := append([]byte(nil), data...)
+	lastComma := -1
+	afterValue := false
+
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '"':
+			start := i
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				} else if out[i] == '\n' {
+					break
+				}
+			}
+			if i >= len(out) || out[i] != '"' {
+				return nil, newSyntaxError(data, start, "unterminated string")
+			}
+			lastComma = -1
+			afterValue = true
+		case c == '#' || c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			start := i
+			out[i], out[i+1] = ' ', ' '
+			for i += 2; ; i++ {
+				if i+1 >= len(out) {
+					return nil, newSyntaxError(data, start, "unterminated comment")
+				}
+				if out[i] == '*' && out[i+1] == '/' {
+					out[i], out[i+1] = ' ', ' '
+					i++
+					break
+				}
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+		case c == ',':
+			if !afterValue {
+				return nil, newSyntaxError(data, i, "unexpected ','")
+			}
+			lastComma = i
+			afterValue = false
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				out[lastComma] = ' '
+			}
+			lastComma = -1
+			afterValue = true
+		case c == '{' || c == '[' || c == ':':
+			lastComma = -1
+			afterValue = false
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		default:
+			lastComma = -1
+			afterValue = true
+		}
+	}
+	return out, nil
+}
+
+func newSyntaxError(data []byte, offset int, msg string) error {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	line, col := 1, 1
+	for _, c := range data[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return &SyntaxError{Line: line, Column: col, Msg: msg}
+}
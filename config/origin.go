@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+type Origin struct {
+	Scheme    string
+	Host      string
+	Port      string
+	Subdomain bool
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+func ParseOrigin(s string) (Origin, error) {
+	raw := strings.TrimSuffix(strings.TrimSpace(s), "/")
+	anyPort := strings.HasSuffix(raw, ":*")
+	u, err := url.Parse(strings.TrimSuffix(raw, ":*"))
+	if err != nil {
+		return Origin{}, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return Origin{}, fmt.Errorf("origin %q needs scheme and host", s)
+	}
+	if u.Path != "" && u.Path != "/" || u.RawQuery != "" || u.Fragment != "" {
+		return Origin{}, fmt.Errorf("origin %q must not contain a path", s)
+	}
+
+	o := Origin{Scheme: strings.ToLower(u.Scheme)}
+	host := u.Host
+	if h, p, err := net.SplitHostPort(u.Host); err == nil {
+		host, o.Port = h, p
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	switch {
+	case anyPort:
+		o.Port = "*"
+	case o.Port == "":
+		o.Port = defaultPort(o.Scheme)
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if strings.HasPrefix(host, "*.") {
+		o.Subdomain = true
+		host = strings.TrimPrefix(host, "*.")
+	}
+	if host == "" || strings.Contains(host, "*") {
+		return Origin{}, fmt.Errorf("origin %q has an invalid host", s)
+	}
+	o.Host = host
+	return o, nil
+}
+
+func (o Origin) Match(other Origin) bool {
+	if o.Scheme != other.Scheme {
+		return false
+	}
+	if o.Port != "*" && o.Port != other.Port {
+		return false
+	}
+	if o.Subdomain {
+		return strings.HasSuffix(other.Host, "."+o.Host)
+	}
+	return o.Host == other.Host
+}
+
+func OriginAllowed(header string, allowed []string) bool {
+	origin, err := ParseOrigin(header)
+	if err != nil || origin.Port == "*" || origin.Subdomain {
+		return false
+	}
+	for _, a := range allowed {
+		if o, err := ParseOrigin(a); err == nil && o.Match(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConfigCamera) AllowedOrigins() []string {
+	return append(append([]string{}, c.Origins...), GetConfigGlobal().Origins...)
+}
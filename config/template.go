@@ -0,0 +1,15 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+)
+
+var TemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
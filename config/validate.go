@@ -0,0 +1,363 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+type Issue struct {
+	Field   string
+	Msg     string
+	Warning bool
+}
+
+func (i Issue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", level, i.Field, i.Msg)
+}
+
+type Issues []Issue
+
+func (is Issues) HasErrors() bool {
+	for _, i := range is {
+		if !i.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func (is *Issues) errorf(field, format string, args ...any) {
+	*is = append(*is, Issue{Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (is *Issues) warnf(field, format string, args ...any) {
+	*is = append(*is, Issue{Field: field, Msg: fmt.Sprintf(format, args...), Warning: true})
+}
+
+func Validate() Issues {
+	return GetConfigGlobal().Validate()
+}
+
+func (g *ConfigGlobal) Validate() Issues {
+	var is Issues
+
+	switch strings.ToLower(g.LoglevelStr) {
+	case "debug", "info", "warn", "error", "verb":
+	default:
+		is.warnf("loglevel", "unknown level %q, using info", g.LoglevelStr)
+	}
+	if g.WSPort <= 0 || g.WSPort > 65535 {
+		is.errorf("ws_port", "must be between 1 and 65535, got %d", g.WSPort)
+	}
+	switch {
+	case (g.TLSCert == "") != (g.TLSKey == ""):
+		is.errorf("tls_cert", "tls_cert and tls_key must be set together")
+	case g.TLSCert != "":
+		if _, err := os.Stat(g.TLSCert); err != nil {
+			is.errorf("tls_cert", "%v", err)
+		}
+		if _, err := os.Stat(g.TLSKey); err != nil {
+			is.errorf("tls_key", "%v", err)
+		}
+	}
+	if g.HTTPPort != 0 {
+		if g.HTTPPort < 0 || g.HTTPPort > 65535 || g.HTTPPort == g.WSPort {
+			is.errorf("http_port", "must be between 1 and 65535 and differ from ws_port, got %d", g.HTTPPort)
+		}
+		if g.HTTPRedirect && g.TLSCert == "" {
+			is.warnf("http_redirect", "ignored without tls_cert and tls_key")
+		}
+	}
+	for i, o := range g.Origins {
+		if _, err := ParseOrigin(o); err != nil {
+			is.errorf(fmt.Sprintf("origins[%d]", i), "%v", err)
+		}
+	}
+	if g.AuthSecret != "" && len(g.AuthSecret) < 16 {
+		is.warnf("auth_secret", "shorter than 16 characters")
+	}
+	if g.AdminPort != 0 {
+		if g.AdminPort < 0 || g.AdminPort > 65535 || g.AdminPort == g.WSPort || g.AdminPort == g.HTTPPort {
+			is.errorf("admin_port", "must be between 1 and 65535 and differ from the other ports, got %d", g.AdminPort)
+		}
+		if g.AdminToken == "" && !isLoopback(g.AdminHost) {
+			is.errorf("admin_token", "required when admin_host %q is not a loopback address", g.AdminHost)
+		}
+	}
+	if g.ReloadAPI && g.AdminToken == "" {
+		is.warnf("reload_api", "disabled without admin_token")
+	}
+	if g.MQTT != nil {
+		validateMQTT(&is, g.MQTT)
+	}
+	if g.PublicURL != "" {
+		if u, err := url.Parse(g.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			is.errorf("public_url", "must be an absolute http(s) URL, got %q", g.PublicURL)
+		}
+	}
+	hooks := make(map[string]int)
+	for i, w := range g.Webhooks {
+		field := fmt.Sprintf("webhooks[%d]", i)
+		if w == nil {
+			is.errorf(field, "empty webhook entry")
+			continue
+		}
+		if w.Name == "" {
+			is.errorf(field+".name", "must not be empty")
+		} else if j, found := hooks[w.Name]; found {
+			is.errorf(field+".name", "duplicate of webhooks[%d].name %q", j, w.Name)
+		} else {
+			hooks[w.Name] = i
+		}
+		validateWebhook(&is, field, w, g.Cameras)
+	}
+	if g.WebhookQueue != "" && !filepath.IsAbs(g.WebhookQueue) {
+		is.errorf("webhook_queue", "%q is not an absolute path", g.WebhookQueue)
+	}
+	if g.WebhookMaxAge < 0 {
+		is.errorf("webhook_max_age", "must not be negative")
+	}
+	if g.WebhookRetry < 0 {
+		is.errorf("webhook_retry", "must not be negative")
+	}
+	if g.ShutdownTimeout < 0 {
+		is.errorf("shutdown_timeout", "must not be negative")
+	}
+	if len(g.Cameras) == 0 {
+		is.errorf("cameras", "no cameras configured")
+	}
+
+	names := make(map[string]int)
+	paths := make(map[string]int)
+	for i, c := range g.Cameras {
+		field := fmt.Sprintf("cameras[%d]", i)
+		if c == nil {
+			is.errorf(field, "empty camera entry")
+			continue
+		}
+
+		if c.Name == "" {
+			is.errorf(field+".name", "must not be empty")
+		} else if strings.EqualFold(c.Name, "events") {
+			is.errorf(field+".name", "%q is reserved for the /api/events stream", c.Name)
+		} else if j, found := names[strings.ToLower(c.Name)]; found {
+			is.errorf(field+".name", "duplicate of cameras[%d].name %q", j, c.Name)
+		} else {
+			names[strings.ToLower(c.Name)] = i
+		}
+
+		switch {
+		case !strings.HasPrefix(c.WSPath, "/"):
+			is.errorf(field+".ws_path", "must start with /, got %q", c.WSPath)
+		case strings.HasPrefix(c.WSPath, "/api/"):
+			is.errorf(field+".ws_path", "%q collides with the /api/ routes", c.WSPath)
+		default:
+			if j, found := paths[c.WSPath]; found {
+				is.errorf(field+".ws_path", "duplicate of cameras[%d].ws_path %q", j, c.WSPath)
+			} else {
+				paths[c.WSPath] = i
+			}
+		}
+
+		switch strings.ToLower(c.WSFormat) {
+		case "", "mpegts", "fmp4":
+		default:
+			is.errorf(field+".ws_format", "must be mpegts or fmp4, got %q", c.WSFormat)
+		}
+		switch strings.ToLower(c.SlowClient) {
+		case "", "drop", "disconnect":
+		default:
+			is.errorf(field+".slow_client", "must be drop or disconnect, got %q", c.SlowClient)
+		}
+
+		if c.AuthSecret != "" && len(c.AuthSecret) < 16 {
+			is.warnf(field+".auth_secret", "shorter than 16 characters")
+		}
+		if len(c.Origins) == 0 && len(g.Origins) == 0 {
+			is.warnf(field+".origins", "empty, all WebSocket clients will be rejected")
+		}
+		for j, o := range c.Origins {
+			if _, err := ParseOrigin(o); err != nil {
+				is.errorf(fmt.Sprintf("%s.origins[%d]", field, j), "%v", err)
+			}
+		}
+
+		if c.FFmpegPath == "" {
+			is.errorf(field+".ffmpeg_path", "must not be empty")
+		} else if !filepath.IsAbs(c.FFmpegPath) {
+			is.warnf(field+".ffmpeg_path", "%q is not an absolute path", c.FFmpegPath)
+		} else if _, err := os.Stat(c.FFmpegPath); err != nil {
+			is.warnf(field+".ffmpeg_path", "%v", err)
+		}
+		if c.RTSPURL == "" && len(c.FFMpegParams) == 0 {
+			is.errorf(field+".rtsp_url", "must be set unless ffmpeg_params are given")
+		}
+
+		if c.HLS {
+			if c.HLSSegment < 0 {
+				is.errorf(field+".hls_segment", "must not be negative")
+			}
+			if c.HLSLowLatency && c.HLSPart > 0 && c.HLSSegment > 0 && c.HLSPart >= c.HLSSegment {
+				is.errorf(field+".hls_part", "must be smaller than hls_segment")
+			}
+		}
+
+		if c.Tracking {
+			validateTracking(&is, field, c)
+		}
+	}
+
+	return is
+}
+
+func validateTracking(is *Issues, field string, c *ConfigCamera) {
+	if c.RecPath == "" {
+		is.errorf(field+".rec_path", "must be set when tracking is enabled")
+	} else if !filepath.IsAbs(c.RecPath) {
+		is.errorf(field+".rec_path", "%q is not an absolute path", c.RecPath)
+	}
+
+	switch strings.ToLower(c.EventSource) {
+	case "", "reolink":
+		if c.Address == "" {
+			is.errorf(field+".addr", "must be set for the reolink event source")
+		}
+	case "onvif":
+		if c.Address == "" && c.OnvifURL == "" {
+			is.errorf(field+".onvif_url", "onvif_url or addr must be set for the onvif event source")
+		}
+	default:
+		is.errorf(field+".event_source", "must be reolink or onvif, got %q", c.EventSource)
+	}
+
+	md, ai := c.MdInterval, c.AiInterval
+	if md <= 0 {
+		md = 3
+	}
+	if ai <= 0 {
+		ai = 7
+	}
+	if md > ai {
+		is.errorf(field+".md_interval", "%d must not be greater than ai_interval %d", md, ai)
+	}
+
+	for j, t := range c.AiTriggers {
+		switch strings.ToLower(strings.TrimSpace(t)) {
+		case "people", "vehicle", "dog_cat", "face":
+		default:
+			is.errorf(fmt.Sprintf("%s.ai_triggers[%d]", field, j), "unknown class %q", t)
+		}
+	}
+
+	r := c.Retention
+	if r.MaxAgeDays < 0 {
+		is.errorf(field+".retention.max_age_days", "must not be negative")
+	}
+	if r.MaxBytes < 0 {
+		is.errorf(field+".retention.max_bytes", "must not be negative")
+	}
+	if r.MinFreeBytes < 0 {
+		is.errorf(field+".retention.min_free_bytes", "must not be negative")
+	}
+	if c.StorageWarn > 0 && c.StorageMin > 0 && c.StorageMin > c.StorageWarn {
+		is.errorf(field+".storage_min_bytes", "must not be greater than storage_warn_bytes")
+	}
+}
+
+func validateMQTT(is *Issues, m *ConfigMQTT) {
+	u, err := url.Parse(m.Broker)
+	switch {
+	case m.Broker == "":
+		is.errorf("mqtt.broker", "must be set")
+	case err != nil:
+		is.errorf("mqtt.broker", "%v", err)
+	case u.Host == "":
+		is.errorf("mqtt.broker", "missing host in %q", m.Broker)
+	default:
+		switch strings.ToLower(u.Scheme) {
+		case "tcp", "mqtt", "ssl", "tls", "mqtts":
+		default:
+			is.errorf("mqtt.broker", "scheme must be tcp, mqtt, ssl, tls or mqtts, got %q", u.Scheme)
+		}
+	}
+	if m.QoS > 1 {
+		is.errorf("mqtt.qos", "must be 0 or 1, got %d", m.QoS)
+	}
+	if m.KeepAlive < 0 || m.KeepAlive > 65535 {
+		is.errorf("mqtt.keepalive", "must be between 0 and 65535, got %d", m.KeepAlive)
+	}
+	if strings.ContainsAny(m.TopicPrefix, "#+") {
+		is.errorf("mqtt.topic_prefix", "must not contain wildcards, got %q", m.TopicPrefix)
+	}
+	if m.TLSCA != "" {
+		if _, err := os.Stat(m.TLSCA); err != nil {
+			is.errorf("mqtt.tls_ca", "%v", err)
+		}
+	}
+	if m.User == "" && m.Pass != "" {
+		is.errorf("mqtt.user", "must be set when pass is set")
+	}
+	if m.TLSInsecure {
+		is.warnf("mqtt.tls_insecure", "broker certificate is not verified")
+	}
+}
+
+func validateWebhook(is *Issues, field string, w *ConfigWebhook, cameras []*ConfigCamera) {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		is.errorf(field+".url", "must be an absolute http(s) URL, got %q", w.URL)
+	}
+	switch strings.ToLower(w.Type) {
+	case "", "custom", "gotify":
+	case "ntfy":
+		if err == nil && strings.Trim(u.Path, "/") == "" {
+			is.errorf(field+".url", "must include the ntfy topic, e.g. https://ntfy.sh/mytopic")
+		}
+	default:
+		is.errorf(field+".type", "must be custom, ntfy or gotify, got %q", w.Type)
+	}
+	switch strings.ToUpper(w.Method) {
+	case "", "POST", "PUT":
+	default:
+		is.errorf(field+".method", "must be POST or PUT, got %q", w.Method)
+	}
+	for j, e := range w.Events {
+		if e != "alarm_start" && e != "alarm_stop" {
+			is.errorf(fmt.Sprintf("%s.events[%d]", field, j), "must be alarm_start or alarm_stop, got %q", e)
+		}
+	}
+	for j, name := range w.Cameras {
+		found := false
+		for _, c := range cameras {
+			found = found || (c != nil && strings.EqualFold(c.Name, name))
+		}
+		if !found {
+			is.warnf(fmt.Sprintf("%s.cameras[%d]", field, j), "unknown camera %q", name)
+		}
+	}
+	if w.Timeout < 0 {
+		is.errorf(field+".timeout", "must not be negative")
+	}
+	if w.Template != "" {
+		if _, err := template.New(w.Name).Funcs(TemplateFuncs).Parse(w.Template); err != nil {
+			is.errorf(field+".template", "%v", err)
+		}
+	}
+}
+
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
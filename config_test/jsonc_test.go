@@ -0,0 +1,80 @@
+package config_test
+
+import (
+	"bv-streamer/config"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalComments(t *testing.T) {
+	src := `{
+  # hash comment
+  "a": 1, // line comment
+  /* block
+     comment */ "b": "x // not a comment # either",
+  "c": [1, /* inline */ 2]
+}`
+	var got map[string]any
+	if err := config.Unmarshal([]byte(src), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"a": 1.0, "b": "x // not a comment # either", "c": []any{1.0, 2.0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestUnmarshalTrailingCommas(t *testing.T) {
+	tests := []struct {
+		src  string
+		want any
+	}{
+		{`[1, 2,]`, []any{1.0, 2.0}},
+		{`{"a": 1,}`, map[string]any{"a": 1.0}},
+		{`{"a": [1,], "b": {"c": true,},}`, map[string]any{"a": []any{1.0}, "b": map[string]any{"c": true}}},
+		{"[\"x\", # comment\n]", []any{"x"}},
+		{"[1, /* , */\n]", []any{1.0}},
+		{`[[],]`, []any{[]any{}}},
+		{`["a,]"]`, []any{"a,]"}},
+	}
+	for _, tt := range tests {
+		var got any
+		if err := config.Unmarshal([]byte(tt.src), &got); err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		src          string
+		line, column int
+	}{
+		{`[,]`, 1, 2},
+		{`{,}`, 1, 2},
+		{`[1,,]`, 1, 4},
+		{`[, 1]`, 1, 2},
+		{`{"a":,}`, 1, 6},
+		{"{\n  \"a\": 1,\n  ,\n}", 3, 3},
+		{"{\n  \"a\": \"open\n}", 2, 8},
+		{"{\n  /* open\n}", 2, 3},
+		{`{"a": 1} {}`, 1, 10},
+	}
+	for _, tt := range tests {
+		var got any
+		err := config.Unmarshal([]byte(tt.src), &got)
+		var syntax *config.SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("%q: got %v, want a SyntaxError", tt.src, err)
+			continue
+		}
+		if syntax.Line != tt.line || syntax.Column != tt.column {
+			t.Errorf("%q: got line %d, column %d, want line %d, column %d (%v)", tt.src, syntax.Line, syntax.Column, tt.line, tt.column, err)
+		}
+	}
+}
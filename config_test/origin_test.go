@@ -0,0 +1,77 @@
+package config_test
+
+import (
+	"bv-streamer/config"
+	"testing"
+)
+
+func TestParseOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   config.Origin
+	}{
+		{"https://a", config.Origin{Scheme: "https", Host: "a", Port: "443"}},
+		{"http://A.example/", config.Origin{Scheme: "http", Host: "a.example", Port: "80"}},
+		{"HTTPS://a:8443", config.Origin{Scheme: "https", Host: "a", Port: "8443"}},
+		{"http://localhost:*", config.Origin{Scheme: "http", Host: "localhost", Port: "*"}},
+		{"https://*.example.com", config.Origin{Scheme: "https", Host: "example.com", Port: "443", Subdomain: true}},
+		{"http://[::1]:3000", config.Origin{Scheme: "http", Host: "::1", Port: "3000"}},
+	}
+	for _, tt := range tests {
+		got, err := config.ParseOrigin(tt.origin)
+		if err != nil {
+			t.Errorf("%s: %v", tt.origin, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.origin, got, tt.want)
+		}
+	}
+
+	for _, origin := range []string{"", "a.example", "https://", "https://a/path", "https://a?x=1", "https://a*.example", "https://*"} {
+		if _, err := config.ParseOrigin(origin); err == nil {
+			t.Errorf("%q: expected an error", origin)
+		}
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		header  string
+		allowed string
+		want    bool
+	}{
+		{"https://a", "https://a", true},
+		{"https://a:443", "https://a", true},
+		{"https://a", "https://a:443", true},
+		{"http://a:80", "http://a", true},
+		{"https://a:8443", "https://a", false},
+		{"http://a", "https://a", false},
+		{"https://a", "http://a:443", false},
+		{"wss://a", "https://a", false},
+		{"https://cams.example.com", "https://*.example.com", true},
+		{"https://a.b.example.com", "https://*.example.com", true},
+		{"https://example.com", "https://*.example.com", false},
+		{"https://badexample.com", "https://*.example.com", false},
+		{"http://localhost:3000", "http://localhost:*", true},
+		{"http://localhost", "http://localhost:*", true},
+		{"https://localhost:3000", "http://localhost:*", false},
+		{"http://localhost:*", "http://localhost:*", false},
+		{"https://*.example.com", "https://*.example.com", false},
+		{"https://A.Example.COM.", "https://a.example.com", true},
+		{"null", "https://a", false},
+		{"", "https://a", false},
+	}
+	for _, tt := range tests {
+		if got := config.OriginAllowed(tt.header, []string{tt.allowed}); got != tt.want {
+			t.Errorf("origin %q with allowed %q: got %v, want %v", tt.header, tt.allowed, got, tt.want)
+		}
+	}
+
+	if !config.OriginAllowed("https://b", []string{"https://a", "https://b"}) {
+		t.Error("second allowed origin did not match")
+	}
+	if config.OriginAllowed("https://a", nil) {
+		t.Error("origin allowed with an empty list")
+	}
+}
@@ -0,0 +1,164 @@
+package config_test
+
+import (
+	"bv-streamer/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func validConfig(t *testing.T) *config.ConfigGlobal {
+	t.Helper()
+	ffmpeg, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &config.ConfigGlobal{
+		LoglevelStr: "info",
+		WSPort:      1510,
+		Origins:     []string{"https://example.com"},
+		Cameras: []*config.ConfigCamera{{
+			Name:       "Garage",
+			WSPath:     "/garage",
+			RTSPURL:    "rtsp://10.0.0.2/main",
+			FFmpegPath: ffmpeg,
+			Tracking:   true,
+			Address:    "10.0.0.2",
+			RecPath:    "/var/rec",
+		}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if issues := validConfig(t).Validate(); len(issues) != 0 {
+		t.Fatalf("valid config has issues %v", issues)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(g *config.ConfigGlobal, c *config.ConfigCamera)
+		field   string
+		warning bool
+	}{
+		{"no cameras", func(g *config.ConfigGlobal, c *config.ConfigCamera) { g.Cameras = nil }, "cameras", false},
+		{"duplicate name", func(g *config.ConfigGlobal, c *config.ConfigCamera) {
+			g.Cameras = append(g.Cameras, &config.ConfigCamera{Name: "garage", WSPath: "/yard", RTSPURL: c.RTSPURL, FFmpegPath: c.FFmpegPath})
+		}, "cameras[1].name", false},
+		{"duplicate ws_path", func(g *config.ConfigGlobal, c *config.ConfigCamera) {
+			g.Cameras = append(g.Cameras, &config.ConfigCamera{Name: "Yard", WSPath: "/garage", RTSPURL: c.RTSPURL, FFmpegPath: c.FFmpegPath})
+		}, "cameras[1].ws_path", false},
+		{"reserved name", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.Name = "Events" }, "cameras[0].name", false},
+		{"relative ws_path", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.WSPath = "garage" }, "cameras[0].ws_path", false},
+		{"api ws_path", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.WSPath = "/api/garage" }, "cameras[0].ws_path", false},
+		{"ws_format", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.WSFormat = "webm" }, "cameras[0].ws_format", false},
+		{"slow_client", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.SlowClient = "wait" }, "cameras[0].slow_client", false},
+		{"missing ffmpeg_path", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.FFmpegPath = "" }, "cameras[0].ffmpeg_path", false},
+		{"relative ffmpeg_path", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.FFmpegPath = "ffmpeg" }, "cameras[0].ffmpeg_path", true},
+		{"missing rtsp_url", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.RTSPURL = "" }, "cameras[0].rtsp_url", false},
+		{"empty origins", func(g *config.ConfigGlobal, c *config.ConfigCamera) { g.Origins = nil }, "cameras[0].origins", true},
+		{"bad origin", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.Origins = []string{"example.com/path"} }, "cameras[0].origins[0]", false},
+		{"missing rec_path", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.RecPath = "" }, "cameras[0].rec_path", false},
+		{"relative rec_path", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.RecPath = "rec" }, "cameras[0].rec_path", false},
+		{"md_interval above ai_interval", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.MdInterval = 10 }, "cameras[0].md_interval", false},
+		{"md_interval above default ai_interval", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.MdInterval, c.AiInterval = 5, 4 }, "cameras[0].md_interval", false},
+		{"missing addr", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.Address = "" }, "cameras[0].addr", false},
+		{"onvif without address", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.EventSource, c.Address = "onvif", "" }, "cameras[0].onvif_url", false},
+		{"unknown ai trigger", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.AiTriggers = []string{"people", "bird"} }, "cameras[0].ai_triggers[1]", false},
+		{"storage_min above storage_warn", func(g *config.ConfigGlobal, c *config.ConfigCamera) { c.StorageWarn, c.StorageMin = 1, 2 }, "cameras[0].storage_min_bytes", false},
+		{"tls_cert without tls_key", func(g *config.ConfigGlobal, c *config.ConfigCamera) { g.TLSCert = "/etc/cert.pem" }, "tls_cert", false},
+		{"http_port equals ws_port", func(g *config.ConfigGlobal, c *config.ConfigCamera) { g.HTTPPort = g.WSPort }, "http_port", false},
+		{"short auth_secret", func(g *config.ConfigGlobal, c *config.ConfigCamera) { g.AuthSecret = "short" }, "auth_secret", true},
+		{"unknown loglevel", func(g *config.ConfigGlobal, c *config.ConfigCamera) { g.LoglevelStr = "loud" }, "loglevel", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := validConfig(t)
+			tt.change(g, g.Cameras[0])
+			issues := g.Validate()
+			found := false
+			for _, issue := range issues {
+				if issue.Field == tt.field && issue.Warning == tt.warning {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("no %s for %s in %v", map[bool]string{false: "error", true: "warning"}[tt.warning], tt.field, issues)
+			}
+			if issues.HasErrors() == tt.warning {
+				t.Fatalf("HasErrors is %v for %v", issues.HasErrors(), issues)
+			}
+		})
+	}
+}
+
+func TestValidateAdminToken(t *testing.T) {
+	tests := []struct {
+		host, token string
+		wantError   bool
+	}{
+		{"127.0.0.1", "", false},
+		{"127.0.0.2", "", false},
+		{"localhost", "", false},
+		{"::1", "", false},
+		{"[::1]", "", false},
+		{"", "", true},
+		{"0.0.0.0", "", true},
+		{"192.168.1.10", "", true},
+		{"192.168.1.10", "secret", false},
+	}
+	for _, tt := range tests {
+		g := &config.ConfigGlobal{WSPort: 1510, AdminHost: tt.host, AdminPort: 1511, AdminToken: tt.token}
+		failed := false
+		for _, issue := range g.Validate() {
+			if issue.Field == "admin_token" && !issue.Warning {
+				failed = true
+			}
+		}
+		if failed != tt.wantError {
+			t.Errorf("admin_host %q with token %q: error %v, want %v", tt.host, tt.token, failed, tt.wantError)
+		}
+	}
+}
+
+func TestReloadKeepsConfigOnErrors(t *testing.T) {
+	ffmpeg, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "bv-streamer.conf")
+	write := func(conf string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"ws_port": 1510, "origins": ["https://example.com"], "cameras": [
+		{"name": "Garage", "ws_path": "/garage", "rtsp_url": "rtsp://10.0.0.2/main", "ffmpeg_path": "` + ffmpeg + `"}]}`)
+	if err := config.Init(&path); err != nil {
+		t.Fatal(err)
+	}
+	current := config.GetConfigGlobal()
+
+	write(`{"ws_port": 1510, "cameras": [
+		{"name": "Garage", "ws_path": "/garage", "rtsp_url": "rtsp://10.0.0.2/main", "ffmpeg_path": "` + ffmpeg + `"},
+		{"name": "Yard", "ws_path": "/garage", "rtsp_url": "rtsp://10.0.0.3/main", "ffmpeg_path": "` + ffmpeg + `"}]}`)
+	old, issues, err := config.Reload(&path)
+	if err == nil || old != nil || !issues.HasErrors() {
+		t.Fatalf("expected a refused reload, got %v %v", issues, err)
+	}
+	if config.GetConfigGlobal() != current {
+		t.Fatal("config replaced despite errors")
+	}
+
+	write(`{"ws_port": 1510, "cameras": [
+		{"name": "Garage", "ws_path": "/garage", "rtsp_url": "rtsp://10.0.0.2/main", "ffmpeg_path": "` + ffmpeg + `"},
+		{"name": "Yard", "ws_path": "/yard", "rtsp_url": "rtsp://10.0.0.3/main", "ffmpeg_path": "` + ffmpeg + `"}]}`)
+	old, issues, err = config.Reload(&path)
+	if err != nil || old != current || issues.HasErrors() {
+		t.Fatalf("expected the reload to apply, got %v %v", issues, err)
+	}
+	if config.GetCamera("yard") == nil {
+		t.Fatal("reloaded camera missing")
+	}
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	EVENT_STORAGE_LOW  Type = "storage_low"
+	EVENT_STORAGE_FULL Type = "storage_full"
+	EVENT_STORAGE_OK   Type = "storage_ok"
+
+	EVENT_MOTION_START Type = "motion_start"
+	EVENT_MOTION_STOP  Type = "motion_stop"
+	EVENT_DETECTION    Type = "detection"
+	EVENT_ALARM_START  Type = "alarm_start"
+	EVENT_ALARM_STOP   Type = "alarm_stop"
+	EVENT_REC_START    Type = "recording_started"
+	EVENT_REC_STOP     Type = "recording_stopped"
+	EVENT_FFMPEG_EXIT  Type = "ffmpeg_exit"
+	EVENT_ARMED        Type = "armed"
+	EVENT_DISARMED     Type = "disarmed"
+)
+
+type Event struct {
+	Type   Type           `json:"type"`
+	Camera string         `json:"camera"`
+	Time   time.Time      `json:"time"`
+	Data   map[string]any `json:"data,omitempty"`
+}
+
+var (
+	mutex       sync.Mutex
+	subscribers = make(map[chan Event]bool)
+)
+
+func Publish(t Type, camera string, data map[string]any) {
+	e := Event{Type: t, Camera: camera, Time: time.Now(), Data: data}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	mutex.Lock()
+	subscribers[ch] = true
+	mutex.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			mutex.Lock()
+			delete(subscribers, ch)
+			mutex.Unlock()
+			close(ch)
+		})
+	}
+}
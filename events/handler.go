@@ -0,0 +1,131 @@
+package events
+
+import (
+	"bv-streamer/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	EVENTS_BUFFER    = 64
+	EVENTS_KEEPALIVE = 15 * time.Second
+	EVENTS_TIMEOUT   = 5 * time.Second
+)
+
+// Serve streams events as SSE, or over a JSON WebSocket when the request asks for an upgrade.
+// An empty camera accepts the ?camera= list, otherwise only that camera is sent.
+func Serve(w http.ResponseWriter, r *http.Request, checkOrigin func(*http.Request) bool, camera string) {
+	filter := make(map[string]bool)
+	if camera != "" {
+		filter[strings.ToLower(camera)] = true
+	} else if q := r.URL.Query().Get("camera"); q != "" {
+		for _, c := range strings.Split(q, ",") {
+			filter[strings.ToLower(strings.TrimSpace(c))] = true
+		}
+	}
+	match := func(e Event) bool {
+		return len(filter) == 0 || filter[strings.ToLower(e.Camera)]
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		serveWS(w, r, checkOrigin, match)
+	} else {
+		serveSSE(w, r, match)
+	}
+}
+
+func serveSSE(w http.ResponseWriter, r *http.Request, match func(Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, unsubscribe := Subscribe(EVENTS_BUFFER)
+	defer unsubscribe()
+	keepalive := time.NewTicker(EVENTS_KEEPALIVE)
+	defer keepalive.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-config.SigShutdown:
+			return
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !match(e) {
+				continue
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func serveWS(w http.ResponseWriter, r *http.Request, checkOrigin func(*http.Request) bool, match func(Event) bool) {
+	upgrader := websocket.Upgrader{CheckOrigin: checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ch, unsubscribe := Subscribe(EVENTS_BUFFER)
+	defer unsubscribe()
+	keepalive := time.NewTicker(EVENTS_KEEPALIVE)
+	defer keepalive.Stop()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-config.SigShutdown:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutdown"), time.Now().Add(time.Second))
+			return
+		case <-closed:
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !match(e) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(EVENTS_TIMEOUT))
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(EVENTS_TIMEOUT)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package events_test
+
+import (
+	"bufio"
+	"bv-streamer/events"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPublish(t *testing.T) {
+	ch, unsubscribe := events.Subscribe(2)
+	events.Publish(events.EVENT_ALARM_START, "Garage", map[string]any{"classes": []string{"people"}})
+	events.Publish(events.EVENT_ALARM_STOP, "Garage", nil)
+	// A full subscriber misses events instead of blocking the publisher.
+	events.Publish(events.EVENT_REC_START, "Garage", nil)
+
+	for _, want := range []events.Type{events.EVENT_ALARM_START, events.EVENT_ALARM_STOP} {
+		if e := <-ch; e.Type != want || e.Camera != "Garage" || e.Time.IsZero() {
+			t.Fatalf("got %+v, want %s", e, want)
+		}
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("expected the overflow to be dropped, got %+v", e)
+	default:
+	}
+
+	unsubscribe()
+	unsubscribe()
+	events.Publish(events.EVENT_ALARM_START, "Garage", nil)
+	if _, ok := <-ch; ok {
+		t.Fatal("expected the channel closed after unsubscribe")
+	}
+}
+
+// publishUntil keeps publishing until received reports the event arrived, so
+// tests do not race the handler subscribing.
+func publishUntil(t *testing.T, camera string, received <-chan events.Event) events.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		events.Publish(events.EVENT_MOTION_START, camera, nil)
+		select {
+		case e := <-received:
+			return e
+		case <-timeout:
+			t.Fatalf("no event for %s", camera)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestServeSSE(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events.Serve(w, r, nil, "")
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?camera=garage,%20Yard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q", ct)
+	}
+
+	received := make(chan events.Event, 16)
+	go func() {
+		defer close(received)
+		scanner := bufio.NewScanner(resp.Body)
+		var typ string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var e events.Event
+				if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e) == nil && string(e.Type) == typ {
+					received <- e
+				}
+			}
+		}
+	}()
+
+	if e := publishUntil(t, "Garage", received); e.Camera != "Garage" || e.Type != events.EVENT_MOTION_START {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	events.Publish(events.EVENT_MOTION_START, "Drive", nil)
+	events.Publish(events.EVENT_MOTION_STOP, "yard", nil)
+	for e := range received {
+		if e.Camera == "Drive" {
+			t.Fatalf("filtered camera sent %+v", e)
+		}
+		if e.Camera == "yard" {
+			break
+		}
+	}
+}
+
+func TestServeWS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events.Serve(w, r, func(r *http.Request) bool {
+			return r.Header.Get("Origin") == "https://example.com"
+		}, "Garage")
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	if _, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}}); err == nil {
+		t.Fatal("expected a foreign origin to be refused")
+	}
+
+	// The fixed camera wins over the query.
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?camera=yard", http.Header{"Origin": {"https://example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	received := make(chan events.Event, 16)
+	go func() {
+		defer close(received)
+		for {
+			var e events.Event
+			if err := conn.ReadJSON(&e); err != nil {
+				return
+			}
+			received <- e
+		}
+	}()
+
+	publishUntil(t, "garage", received)
+	events.Publish(events.EVENT_DETECTION, "Yard", nil)
+	events.Publish(events.EVENT_DETECTION, "Garage", map[string]any{"class": "people"})
+	for e := range received {
+		if e.Camera == "Yard" {
+			t.Fatalf("filtered camera sent %+v", e)
+		}
+		if e.Type == events.EVENT_DETECTION {
+			if e.Data["class"] != "people" {
+				t.Fatalf("unexpected data %v", e.Data)
+			}
+			break
+		}
+	}
+}
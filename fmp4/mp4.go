@@ -0,0 +1,149 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+const (
+	TIMESCALE = 90000
+	TRACK_ID  = 1
+
+	sampleFlagsKey    uint32 = 0x02000000
+	sampleFlagsNonKey uint32 = 0x01010000
+)
+
+type Sample struct {
+	Data      []byte
+	Duration  uint32
+	CTSOffset int32
+	Keyframe  bool
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func u64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func fullBox(typ string, version byte, flags uint32, payload ...[]byte) []byte {
+	head := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(typ, append([][]byte{head}, payload...)...)
+}
+
+var matrix = []byte{
+	0x00, 0x01, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0x00, 0x01, 0x00, 0x00, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0x00, 0x00, 0x00,
+}
+
+func InitSegment(sps, pps []byte, info SPSInfo) []byte {
+	ftyp := box("ftyp", []byte("iso5"), u32(512), []byte("iso5iso6avc1mp41"))
+
+	mvhd := fullBox("mvhd", 0, 0,
+		u32(0), u32(0), u32(1000), u32(0),
+		u32(0x00010000), u16(0x0100), make([]byte, 10),
+		matrix, make([]byte, 24), u32(TRACK_ID+1))
+
+	tkhd := fullBox("tkhd", 0, 3,
+		u32(0), u32(0), u32(TRACK_ID), u32(0), u32(0),
+		make([]byte, 8), u16(0), u16(0), u16(0), u16(0),
+		matrix, u32(uint32(info.Width)<<16), u32(uint32(info.Height)<<16))
+
+	mdhd := fullBox("mdhd", 0, 0, u32(0), u32(0), u32(TIMESCALE), u32(0), u16(0x55c4), u16(0))
+	hdlr := fullBox("hdlr", 0, 0, u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+
+	avcC := box("avcC",
+		[]byte{1, info.Profile, info.Compatibility, info.Level, 0xff, 0xe1},
+		u16(uint16(len(sps))), sps,
+		[]byte{1}, u16(uint16(len(pps))), pps)
+	avc1 := box("avc1",
+		make([]byte, 6), u16(1), make([]byte, 16),
+		u16(uint16(info.Width)), u16(uint16(info.Height)),
+		u32(0x00480000), u32(0x00480000), u32(0), u16(1),
+		make([]byte, 32), u16(0x0018), u16(0xffff), avcC)
+
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u32(1), avc1),
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)))
+	minf := box("minf",
+		fullBox("vmhd", 0, 1, make([]byte, 8)),
+		box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1))),
+		stbl)
+
+	trak := box("trak", tkhd, box("mdia", mdhd, hdlr, minf))
+	mvex := box("mvex", fullBox("trex", 0, 0, u32(TRACK_ID), u32(1), u32(0), u32(0), u32(0)))
+
+	return append(ftyp, box("moov", mvhd, trak, mvex)...)
+}
+
+func Fragment(seq uint32, baseDTS uint64, samples []Sample) []byte {
+	entries := make([]byte, 0, 16*len(samples))
+	mdatSize := 8
+	for _, s := range samples {
+		flags := sampleFlagsNonKey
+		if s.Keyframe {
+			flags = sampleFlagsKey
+		}
+		entries = binary.BigEndian.AppendUint32(entries, s.Duration)
+		entries = binary.BigEndian.AppendUint32(entries, uint32(len(s.Data)))
+		entries = binary.BigEndian.AppendUint32(entries, flags)
+		entries = binary.BigEndian.AppendUint32(entries, uint32(s.CTSOffset))
+		mdatSize += len(s.Data)
+	}
+
+	build := func(offset uint32) []byte {
+		trun := fullBox("trun", 1, 0x000f01, u32(uint32(len(samples))), u32(offset), entries)
+		traf := box("traf",
+			fullBox("tfhd", 0, 0x020000, u32(TRACK_ID)),
+			fullBox("tfdt", 1, 0, u64(baseDTS)),
+			trun)
+		return box("moof", fullBox("mfhd", 0, 0, u32(seq)), traf)
+	}
+	moof := build(0)
+	moof = build(uint32(len(moof) + 8))
+
+	out := make([]byte, 0, len(moof)+mdatSize)
+	out = append(out, moof...)
+	out = binary.BigEndian.AppendUint32(out, uint32(mdatSize))
+	out = append(out, "mdat"...)
+	for _, s := range samples {
+		out = append(out, s.Data...)
+	}
+	return out
+}
+
+func AVCC(nals [][]byte) []byte {
+	size := 0
+	for _, n := range nals {
+		size += 4 + len(n)
+	}
+	out := make([]byte, 0, size)
+	for _, n := range nals {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(n)))
+		out = append(out, n...)
+	}
+	return out
+}
@@ -0,0 +1,210 @@
+package fmp4
+
+import (
+	"errors"
+	"fmt"
+)
+
+type SPSInfo struct {
+	Profile       byte
+	Compatibility byte
+	Level         byte
+	Width         int
+	Height        int
+}
+
+func (s SPSInfo) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", s.Profile, s.Compatibility, s.Level)
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (b *bitReader) bit() (uint, error) {
+	if b.pos >= len(b.data)*8 {
+		return 0, errors.New("sps: unexpected end of data")
+	}
+	v := uint(b.data[b.pos/8]>>(7-b.pos%8)) & 1
+	b.pos++
+	return v, nil
+}
+
+func (b *bitReader) bits(n int) (uint, error) {
+	var v uint
+	for i := 0; i < n; i++ {
+		bit, err := b.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	return v, nil
+}
+
+func (b *bitReader) ue() (uint, error) {
+	zeros := 0
+	for {
+		bit, err := b.bit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, errors.New("sps: invalid exp-golomb code")
+		}
+	}
+	v, err := b.bits(zeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1 << zeros) - 1 + v, nil
+}
+
+func (b *bitReader) se() (int, error) {
+	v, err := b.ue()
+	if err != nil {
+		return 0, err
+	}
+	if v&1 == 1 {
+		return int(v+1) / 2, nil
+	}
+	return -int(v / 2), nil
+}
+
+func unescape(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, c := range nal {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func ParseSPS(nal []byte) (SPSInfo, error) {
+	var info SPSInfo
+	if len(nal) < 4 {
+		return info, errors.New("sps: too short")
+	}
+	info.Profile, info.Compatibility, info.Level = nal[1], nal[2], nal[3]
+
+	r := &bitReader{data: unescape(nal[4:])}
+	// Every read goes through these, so the first error sticks and later
+	// reads of a truncated SPS only yield zeros.
+	var err error
+	check := func(v uint, e error) uint {
+		if err == nil {
+			err = e
+		}
+		if err != nil {
+			return 0
+		}
+		return v
+	}
+	ue := func() uint { return check(r.ue()) }
+	bit := func() uint { return check(r.bit()) }
+	se := func() int {
+		v, e := r.se()
+		check(0, e)
+		return v
+	}
+
+	ue() // seq_parameter_set_id
+	chroma := uint(1)
+	switch info.Profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chroma = ue()
+		if chroma == 3 {
+			bit() // separate_colour_plane_flag
+		}
+		ue()  // bit_depth_luma_minus8
+		ue()  // bit_depth_chroma_minus8
+		bit() // qpprime_y_zero_transform_bypass_flag
+		if bit() == 1 {
+			lists := 8
+			if chroma == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists && err == nil; i++ {
+				if bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size && err == nil; j++ {
+					if next != 0 {
+						next = (last + se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	ue() // log2_max_frame_num_minus4
+	switch ue() {
+	case 0:
+		ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		bit()
+		se()
+		se()
+		cycle := ue()
+		for i := uint(0); i < cycle && err == nil; i++ {
+			se()
+		}
+	}
+	ue()  // max_num_ref_frames
+	bit() // gaps_in_frame_num_value_allowed_flag
+
+	widthMbs := ue()
+	heightMaps := ue()
+	frameMbsOnly := bit()
+	if frameMbsOnly == 0 {
+		bit() // mb_adaptive_frame_field_flag
+	}
+	bit() // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if bit() == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = ue(), ue(), ue(), ue()
+	}
+	if err != nil {
+		return info, err
+	}
+
+	cropX, cropY := uint(1), 2-frameMbsOnly
+	switch chroma {
+	case 1:
+		cropX, cropY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropX = 2
+	}
+
+	width := int((widthMbs + 1) * 16)
+	height := int((2 - frameMbsOnly) * (heightMaps + 1) * 16)
+	info.Width = width - int(cropX*(cropLeft+cropRight))
+	info.Height = height - int(cropY*(cropTop+cropBottom))
+	if info.Width <= 0 || info.Height <= 0 {
+		return info, fmt.Errorf("sps: cropping exceeds %dx%d", width, height)
+	}
+	return info, nil
+}
@@ -0,0 +1,89 @@
+package fmp4_test
+
+import (
+	"bv-streamer/fmp4"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Bytes before the child boxes of each container box.
+var containers = map[string]int{
+	"moov": 0, "trak": 0, "mdia": 0, "minf": 0, "dinf": 0, "stbl": 0, "mvex": 0,
+	"stsd": 8, "dref": 8, "avc1": 78,
+}
+
+// dump prints one line per box with the payload of leaf boxes in hex.
+func dump(b *strings.Builder, data []byte, depth int) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return fmt.Errorf("%d trailing bytes", len(data))
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			return fmt.Errorf("box size %d exceeds %d bytes", size, len(data))
+		}
+		typ, payload := string(data[4:8]), data[8:size]
+		fmt.Fprintf(b, "%s%s", strings.Repeat("  ", depth), typ)
+		if skip, ok := containers[typ]; ok {
+			if skip > 0 {
+				fmt.Fprintf(b, " %s", hex.EncodeToString(payload[:skip]))
+			}
+			b.WriteString("\n")
+			if err := dump(b, payload[skip:], depth+1); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintf(b, " %s\n", hex.EncodeToString(payload))
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+const initGolden = `ftyp 69736f350000020069736f3569736f36617663316d703431
+moov
+  mvhd 000000000000000000000000000003e8000000000001000001000000000000000000000000010000000000000000000000000000000100000000000000000000000000004000000000000000000000000000000000000000000000000000000000000002
+  trak
+    tkhd 000000030000000000000000000000010000000000000000000000000000000000000000000000000001000000000000000000000000000000010000000000000000000000000000400000000780000004380000
+    mdia
+      mdhd 00000000000000000000000000015f900000000055c40000
+      hdlr 000000000000000076696465000000000000000000000000566964656f48616e646c657200
+      minf
+        vmhd 000000010000000000000000
+        dinf
+          dref 0000000000000001
+            url  00000001
+        stbl
+          stsd 0000000000000001
+            avc1 00000000000000010000000000000000000000000000000007800438004800000048000000000000000100000000000000000000000000000000000000000000000000000000000000000018ffff
+              avcC 01640028ffe1000c67640028acd940780227e54001000668ebe3cb22c0
+          stts 0000000000000000
+          stsc 0000000000000000
+          stsz 000000000000000000000000
+          stco 0000000000000000
+  mvex
+    trex 000000000000000100000001000000000000000000000000
+`
+
+func TestInitSegment(t *testing.T) {
+	sps := buildSPS(spsParams{profile: 100, chroma: 1, widthMbs: 119, heightMaps: 67, frameMbsOnly: true, crop: [4]uint{0, 0, 0, 4}})
+	pps := []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+	info, err := fmp4.ParseSPS(sps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Codec() != "avc1.640028" {
+		t.Errorf("got codec %s", info.Codec())
+	}
+
+	var b strings.Builder
+	if err := dump(&b, fmp4.InitSegment(sps, pps, info), 0); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != initGolden {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), initGolden)
+	}
+}
@@ -0,0 +1,203 @@
+package fmp4_test
+
+import (
+	"bv-streamer/fmp4"
+	"testing"
+)
+
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) bit(v uint) {
+	if w.n%8 == 0 {
+		w.data = append(w.data, 0)
+	}
+	w.data[len(w.data)-1] |= byte(v&1) << (7 - w.n%8)
+	w.n++
+}
+
+func (w *bitWriter) bits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> i)
+	}
+}
+
+func (w *bitWriter) ue(v uint) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.bits(0, n)
+	w.bits(v, n+1)
+}
+
+func (w *bitWriter) se(v int) {
+	if v > 0 {
+		w.ue(uint(2*v - 1))
+	} else {
+		w.ue(uint(-2 * v))
+	}
+}
+
+// escape inserts emulation prevention bytes.
+func escape(rbsp []byte) []byte {
+	var out []byte
+	zeros := 0
+	for _, c := range rbsp {
+		if zeros >= 2 && c <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+type spsParams struct {
+	profile       byte
+	chroma        uint
+	scaling       bool
+	pocType       uint
+	widthMbs      uint
+	heightMaps    uint
+	frameMbsOnly  bool
+	crop          [4]uint
+	truncateBytes int
+	truncateCrop  bool // cut the SPS inside the cropping offsets
+}
+
+func buildSPS(p spsParams) []byte {
+	w := &bitWriter{}
+	w.ue(0) // seq_parameter_set_id
+	if p.profile == 100 {
+		w.ue(p.chroma)
+		w.ue(0)
+		w.ue(0)
+		w.bit(0)
+		if p.scaling {
+			w.bit(1)
+			for i := range 8 {
+				// Lists 0 and 6 carry a delta scaling list, the others
+				// fall back to the defaults.
+				if i != 0 && i != 6 {
+					w.bit(0)
+					continue
+				}
+				w.bit(1)
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				for j := range size {
+					switch {
+					case j == 0:
+						w.se(8)
+					case j%2 == 1:
+						w.se(-3)
+					default:
+						w.se(3)
+					}
+				}
+			}
+		} else {
+			w.bit(0)
+		}
+	}
+	w.ue(0) // log2_max_frame_num_minus4
+	w.ue(p.pocType)
+	switch p.pocType {
+	case 0:
+		w.ue(2)
+	case 1:
+		w.bit(0)
+		w.se(-1)
+		w.se(2)
+		w.ue(2)
+		w.se(1)
+		w.se(-2)
+	}
+	w.ue(4) // max_num_ref_frames
+	w.bit(0)
+	w.ue(p.widthMbs)
+	w.ue(p.heightMaps)
+	if p.frameMbsOnly {
+		w.bit(1)
+	} else {
+		w.bit(0)
+		w.bit(1)
+	}
+	w.bit(1) // direct_8x8_inference_flag
+	cropAt := -1
+	if p.crop != [4]uint{} {
+		w.bit(1)
+		cropAt = w.n
+		for _, c := range p.crop {
+			w.ue(c)
+		}
+	} else {
+		w.bit(0)
+	}
+	w.bit(0) // vui_parameters_present_flag
+	w.bit(1) // rbsp_stop_one_bit
+
+	rbsp := w.data[:len(w.data)-p.truncateBytes]
+	if p.truncateCrop {
+		rbsp = w.data[:(cropAt+7)/8]
+	}
+	return append([]byte{0x67, p.profile, 0x00, 0x28}, escape(rbsp)...)
+}
+
+func TestParseSPS(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        spsParams
+		width, height int
+		wantErr       bool
+	}{
+		{"baseline", spsParams{profile: 66, widthMbs: 39, heightMaps: 29, frameMbsOnly: true}, 640, 480, false},
+		{"baseline poc type 1", spsParams{profile: 66, pocType: 1, widthMbs: 21, heightMaps: 17, frameMbsOnly: true}, 352, 288, false},
+		{"high", spsParams{profile: 100, chroma: 1, widthMbs: 79, heightMaps: 44, frameMbsOnly: true}, 1280, 720, false},
+		{"high with scaling lists", spsParams{profile: 100, chroma: 1, scaling: true, widthMbs: 79, heightMaps: 44, frameMbsOnly: true}, 1280, 720, false},
+		{"1080p uncropped", spsParams{profile: 100, chroma: 1, widthMbs: 119, heightMaps: 67, frameMbsOnly: true}, 1920, 1088, false},
+		{"1080p cropped", spsParams{profile: 100, chroma: 1, widthMbs: 119, heightMaps: 67, frameMbsOnly: true, crop: [4]uint{0, 0, 0, 4}}, 1920, 1080, false},
+		{"1080i cropped", spsParams{profile: 100, chroma: 1, widthMbs: 119, heightMaps: 33, crop: [4]uint{0, 0, 0, 2}}, 1920, 1080, false},
+		{"4:2:2 cropped", spsParams{profile: 100, chroma: 2, widthMbs: 119, heightMaps: 67, frameMbsOnly: true, crop: [4]uint{1, 1, 0, 8}}, 1916, 1080, false},
+		{"crop exceeds picture", spsParams{profile: 66, widthMbs: 0, heightMaps: 0, frameMbsOnly: true, crop: [4]uint{4, 4, 0, 0}}, 0, 0, true},
+		{"truncated in scaling lists", spsParams{profile: 100, chroma: 1, scaling: true, widthMbs: 79, heightMaps: 44, frameMbsOnly: true, truncateBytes: 40}, 0, 0, true},
+		{"truncated in dimensions", spsParams{profile: 66, widthMbs: 119, heightMaps: 67, frameMbsOnly: true, truncateBytes: 2}, 0, 0, true},
+		{"truncated in cropping", spsParams{profile: 100, chroma: 1, widthMbs: 119, heightMaps: 67, frameMbsOnly: true, crop: [4]uint{0, 0, 0, 4}, truncateCrop: true}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nal := buildSPS(tt.params)
+			info, err := fmp4.ParseSPS(nal)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %dx%d, want an error", info.Width, info.Height)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Width != tt.width || info.Height != tt.height {
+				t.Fatalf("got %dx%d, want %dx%d", info.Width, info.Height, tt.width, tt.height)
+			}
+			if info.Profile != tt.params.profile || info.Level != 0x28 {
+				t.Fatalf("got profile %d level %d", info.Profile, info.Level)
+			}
+		})
+	}
+
+	if _, err := fmp4.ParseSPS([]byte{0x67, 0x42}); err == nil {
+		t.Fatal("expected an error for a 2 byte SPS")
+	}
+}
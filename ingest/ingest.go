@@ -0,0 +1,281 @@
+package ingest
+
+import (
+	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/lifecycle"
+	"bv-streamer/log"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	restartDelay time.Duration = 3 * time.Second
+)
+
+type Resetter interface {
+	Reset()
+}
+
+type Ingest struct {
+	cfg *config.ConfigCamera
+
+	mutex        sync.Mutex
+	ffmpegCmd    *exec.Cmd
+	restartCount int
+	consumers    map[io.Writer]bool
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func NewIngest(c *config.ConfigCamera) *Ingest {
+	i := &Ingest{
+		cfg:       c,
+		consumers: make(map[io.Writer]bool),
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-config.SigShutdown:
+			i.Close()
+		case <-i.done:
+		}
+	}()
+	lifecycle.Go(c.Name+"/ffmpeg", i.ffmpegRunner)
+
+	return i
+}
+
+func (i *Ingest) Subscribe(w io.Writer) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.consumers[w] = true
+}
+
+func (i *Ingest) Unsubscribe(w io.Writer) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	delete(i.consumers, w)
+}
+
+func (i *Ingest) Status() IngestStatus {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	st := IngestStatus{Restarts: i.restartCount, Consumers: len(i.consumers)}
+	if i.ffmpegCmd != nil && i.ffmpegCmd.Process != nil {
+		st.Running = true
+		st.PID = i.ffmpegCmd.Process.Pid
+	}
+	return st
+}
+
+func (i *Ingest) Close() {
+	i.closeOnce.Do(func() {
+		log.Infof("[%s] Closing ingest...", i.cfg.Name)
+		close(i.done)
+	})
+}
+
+func (i *Ingest) ffmpegRunner() {
+
+	log.Infof("[%s] FFmpeg-runner start.", i.cfg.Name)
+	ffmpegDone := make(chan error, 1)
+	var ffmpegRunning bool
+
+	for {
+
+		i.mutex.Lock()
+		hasConsumers := len(i.consumers) > 0
+		i.mutex.Unlock()
+
+		select {
+		case <-i.done:
+			log.Infof("[%s] FFmpeg-runner stop (shutdown signal).", i.cfg.Name)
+			i.destroyFFmpeg()
+			if ffmpegRunning {
+				<-ffmpegDone
+			}
+			return
+		case err := <-ffmpegDone:
+			log.Errorf("[%s] FFmpeg exited: %v", i.cfg.Name, err)
+			metricExits.Inc(i.cfg.Name, exitCode(err))
+			events.Publish(events.EVENT_FFMPEG_EXIT, i.cfg.Name, map[string]any{"code": exitCode(err)})
+			ffmpegRunning = false
+			i.mutex.Lock()
+			i.ffmpegCmd = nil
+			i.mutex.Unlock()
+			i.reset()
+			if hasConsumers {
+				i.mutex.Lock()
+				i.restartCount++
+				i.mutex.Unlock()
+				metricRestarts.Inc(i.cfg.Name)
+				log.Infof("[%s] Restarting ffmpeg in %v...", i.cfg.Name, restartDelay)
+				time.Sleep(restartDelay)
+			}
+		case <-time.After(1 * time.Second):
+			switch {
+			case !ffmpegRunning && hasConsumers:
+				stdout, stderr, err := i.createFFmpeg()
+				if err != nil {
+					log.Errorf("[%s] Failed to start ffmpeg: %v", i.cfg.Name, err)
+					time.Sleep(restartDelay)
+					continue
+				}
+				go i.pipeRunner(stdout, stderr)
+				i.mutex.Lock()
+				cmd := i.ffmpegCmd
+				i.mutex.Unlock()
+				ffmpegRunning = true
+				go func() {
+					ffmpegDone <- cmd.Wait()
+				}()
+				log.Debugf("[%s] FFmpeg started.", i.cfg.Name)
+			case ffmpegRunning && !hasConsumers:
+				log.Infof("[%s] No consumers left, stopping ffmpeg...", i.cfg.Name)
+				i.destroyFFmpeg()
+				ffmpegRunning = false
+			}
+		}
+	}
+}
+
+func (i *Ingest) createFFmpeg() (io.ReadCloser, io.ReadCloser, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if len(i.cfg.FFMpegParams) > 0 {
+		log.Debugf("[%s] Found ffmpeg parameters. Using it.", i.cfg.Name)
+		args := append([]string{}, i.cfg.FFMpegParams...)
+		args = append(args, "pipe:1")
+		i.ffmpegCmd = exec.Command(i.cfg.FFmpegPath, args...)
+
+	} else {
+		log.Infof("[%s] No ffmpeg parameters set. Using default.", i.cfg.Name)
+		i.ffmpegCmd = exec.Command(i.cfg.FFmpegPath,
+			"-loglevel", "warning",
+			"-rtsp_transport", "tcp",
+			"-fflags", "+genpts",
+			"-analyzeduration", "500000",
+			"-probesize", "512k",
+			"-i", i.cfg.RTSPURL,
+			"-map", "0:v",
+			"-c:v", "copy",
+			"-f", "mpegts",
+			"pipe:1",
+		)
+	}
+
+	stdout, err := i.ffmpegCmd.StdoutPipe()
+	stderr, _ := i.ffmpegCmd.StderrPipe()
+	if err != nil {
+		log.Errorf("[%s] Error creating StdoutPipe: %v", i.cfg.Name, err)
+		i.ffmpegCmd = nil
+		return nil, nil, err
+	}
+
+	log.Infof("[%s] Starting ffmpeg (PIPE mode)...", i.cfg.Name)
+	if err := i.ffmpegCmd.Start(); err != nil {
+		log.Errorf("[%s] Error starting ffmpeg: %v", i.cfg.Name, err)
+		i.ffmpegCmd = nil
+		return nil, nil, err
+	}
+	return stdout, stderr, nil
+}
+
+func (i *Ingest) pipeRunner(streampipe io.ReadCloser, errpipe io.ReadCloser) {
+	defer func() {
+		streampipe.Close()
+		errpipe.Close()
+	}()
+
+	buf := make([]byte, 8*1024)
+	log.Infof("[%s] Pipe-runner started...", i.cfg.Name)
+
+	go func() {
+		errbuf := make([]byte, 8*1024)
+
+		log.Infof("[%s] FFmpeg-errorpipe-runner start.", i.cfg.Name)
+		for {
+			select {
+			case <-i.done:
+				log.Infof("[%s] FFmpeg-errorpipe-runner stop.", i.cfg.Name)
+				return
+			default:
+				n, err := errpipe.Read(errbuf)
+				if err == nil {
+					if n > 0 {
+						log.Infof("[%s] FFmpeg stderr: %s", i.cfg.Name, string(errbuf[:n]))
+					}
+				} else {
+					log.Debugf("[%s] FFmpeg-errorpipe-runner stop.", i.cfg.Name)
+					return
+				}
+			}
+		}
+
+	}()
+
+	log.Infof("[%s] FFmpeg-streampipe-runner start.", i.cfg.Name)
+	for {
+		select {
+		case <-i.done:
+			log.Infof("[%s] FFmpeg-streampipe-runner stop.", i.cfg.Name)
+			return
+		default:
+			n, err := streampipe.Read(buf)
+			if err != nil {
+				log.Errorf("[%s] Error reading from ffmpeg PIPE: %v", i.cfg.Name, err)
+				log.Infof("[%s] FFmpeg-streampipe-runner stop.", i.cfg.Name)
+				return
+			}
+			metricBytes.Add(float64(n), i.cfg.Name)
+
+			i.mutex.Lock()
+			consumers := make([]io.Writer, 0, len(i.consumers))
+			for c := range i.consumers {
+				consumers = append(consumers, c)
+			}
+			i.mutex.Unlock()
+
+			for _, c := range consumers {
+				if _, err := c.Write(buf[:n]); err != nil {
+					log.Errorf("[%s] Consumer write error: %v", i.cfg.Name, err)
+				}
+			}
+		}
+	}
+
+}
+
+func (i *Ingest) reset() {
+	i.mutex.Lock()
+	consumers := make([]io.Writer, 0, len(i.consumers))
+	for c := range i.consumers {
+		consumers = append(consumers, c)
+	}
+	i.mutex.Unlock()
+
+	for _, c := range consumers {
+		if r, ok := c.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
+
+func (i *Ingest) destroyFFmpeg() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.ffmpegCmd != nil {
+		log.Infof("[%s] Destroy ffmpeg process...", i.cfg.Name)
+		if err := i.ffmpegCmd.Process.Kill(); err != nil {
+			log.Errorf("[%s] Failed to terminate ffmpeg-process: %v", i.cfg.Name, err)
+		}
+		i.ffmpegCmd = nil
+		i.restartCount = 0
+	}
+}
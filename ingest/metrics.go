@@ -0,0 +1,25 @@
+package ingest
+
+import (
+	"bv-streamer/metrics"
+	"errors"
+	"os/exec"
+	"strconv"
+)
+
+var (
+	metricBytes    = metrics.NewCounter("bv_ingest_bytes_total", "Bytes read from the ffmpeg pipe.", "camera")
+	metricRestarts = metrics.NewCounter("bv_ffmpeg_restarts_total", "Restarts of the ffmpeg ingest process.", "camera")
+	metricExits    = metrics.NewCounter("bv_ffmpeg_exits_total", "Exits of the ffmpeg ingest process by exit code.", "camera", "code")
+)
+
+func exitCode(err error) string {
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return "0"
+	case errors.As(err, &exit):
+		return strconv.Itoa(exit.ExitCode())
+	}
+	return "-1"
+}
@@ -0,0 +1,8 @@
+package ingest
+
+type IngestStatus struct {
+	Running   bool `json:"ffmpeg_running"`
+	PID       int  `json:"ffmpeg_pid,omitempty"`
+	Restarts  int  `json:"ffmpeg_restarts"`
+	Consumers int  `json:"consumers"`
+}
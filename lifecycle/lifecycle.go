@@ -0,0 +1,71 @@
+package lifecycle
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+var (
+	mutex   sync.Mutex
+	pending = make(map[int]string)
+	idle    chan struct{}
+	next    int
+)
+
+func Track(name string) func() {
+	mutex.Lock()
+	id := next
+	next++
+	pending[id] = name
+	mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+			delete(pending, id)
+			if len(pending) == 0 && idle != nil {
+				close(idle)
+				idle = nil
+			}
+		})
+	}
+}
+
+func Go(name string, fn func()) {
+	done := Track(name)
+	go func() {
+		defer done()
+		fn()
+	}()
+}
+
+func Wait(ctx context.Context) []string {
+	mutex.Lock()
+	if len(pending) == 0 {
+		mutex.Unlock()
+		return nil
+	}
+	if idle == nil {
+		idle = make(chan struct{})
+	}
+	finished := idle
+	mutex.Unlock()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	names := make([]string, 0, len(pending))
+	for _, name := range pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
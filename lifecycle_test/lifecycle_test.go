@@ -0,0 +1,72 @@
+package lifecycle_test
+
+import (
+	"bv-streamer/lifecycle"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	shutdown := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	finish := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, name)
+	}
+
+	// Components stop on the shutdown signal, the alarm only after a final
+	// write like a remux would do.
+	lifecycle.Go("garage/ffmpeg", func() {
+		<-shutdown
+		finish("garage/ffmpeg")
+	})
+	lifecycle.Go("garage/alarm", func() {
+		<-shutdown
+		time.Sleep(50 * time.Millisecond)
+		finish("garage/alarm")
+	})
+	release := lifecycle.Track("garage/streamer")
+	go func() {
+		<-shutdown
+		finish("garage/streamer")
+		release()
+		release()
+	}()
+
+	close(shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if pending := lifecycle.Wait(ctx); pending != nil {
+		t.Fatalf("unexpected pending components %v", pending)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 3 || order[2] != "garage/alarm" {
+		t.Fatalf("Wait returned before all components stopped, got %v", order)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	lifecycle.Go("yard/ffmpeg", func() { <-stuck })
+	lifecycle.Go("garage/alarm", func() { <-stuck })
+	lifecycle.Go("garage/ffmpeg", func() {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	pending := lifecycle.Wait(ctx)
+	if time.Since(start) > time.Second {
+		t.Fatal("Wait ignored the timeout")
+	}
+	if want := []string{"garage/alarm", "yard/ffmpeg"}; !slices.Equal(pending, want) {
+		t.Fatalf("got pending %v, want %v", pending, want)
+	}
+}
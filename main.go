@@ -1,20 +1,38 @@
 package main
 
 import (
+	"bv-streamer/admin"
+	"bv-streamer/certs"
 	"bv-streamer/config"
+	"bv-streamer/lifecycle"
+	"bv-streamer/metrics"
+	"bv-streamer/mqtt"
+	"bv-streamer/recordings"
 	"bv-streamer/streamer"
+	"bv-streamer/webhook"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
+const SHUTDOWN_TIMEOUT_DEFAULT = 10 * time.Second
+
 var sigs = make(chan os.Signal, 1)
 var done = make(chan struct{})
+var serversMu sync.Mutex
+var servers []*http.Server
 
 func main() {
 	log.SetPrefix("[bv-streamer]")
@@ -22,37 +40,237 @@ func main() {
 	ShutdownHandler()
 
 	var path string
+	var check bool
+	var sign string
+	var signTTL time.Duration
 	flag.StringVar(&path, "config", "", "Path to config file.")
+	flag.BoolVar(&check, "check-config", false, "Validate the config file and exit.")
+	flag.StringVar(&sign, "sign", "", "Print a signed query string for the named camera (or \"events\") and exit.")
+	flag.DurationVar(&signTTL, "sign-ttl", 5*time.Minute, "Validity of the signed query string.")
 	flag.Parse()
 
+	if check {
+		os.Exit(CheckConfig(&path))
+	}
+	if sign != "" {
+		os.Exit(SignURL(&path, sign, signTTL))
+	}
+
 	var err error
 	if err = config.Init(&path); err != nil {
 		log.Fatalf("Config-Error: %v", err)
 	}
 
-	if len(config.GetCameras()) == 0 {
-		log.Println("No cameras found in config. Exit.")
-		sigs <- syscall.SIGTERM
-	} else {
-		for i := range config.GetCameras() {
-			var cfg *config.ConfigCamera = config.GetCameras()[i]
-			if s := streamer.NewStreamer(cfg); s != nil {
-				log.Printf("Start streaming for %s", cfg.Name)
-				go s.Start()
+	issues := config.Validate()
+	for _, issue := range issues {
+		log.Printf("Config-%v", issue)
+	}
+	if issues.HasErrors() {
+		log.Fatalln("Config has errors. Exit.")
+	}
+
+	for i := range config.GetCameras() {
+		var cfg *config.ConfigCamera = config.GetCameras()[i]
+		if s := streamer.NewStreamer(cfg); s != nil {
+			log.Printf("Start streaming for %s", cfg.Name)
+			go s.Start()
+		} else {
+			log.Printf("Failed to start streaming for %s", cfg.Name)
+		}
+	}
+
+	if cfg := config.GetConfigGlobal().MQTT; cfg != nil {
+		log.Printf("MQTT enabled, broker %s", cfg.Broker)
+		bridge := mqtt.NewBridge(cfg, config.GetCameras, streamer.SetArmed)
+		lifecycle.Go("mqtt", func() { bridge.Run(config.SigShutdown) })
+	}
+
+	if len(config.GetConfigGlobal().Webhooks) > 0 {
+		if d, err := webhook.NewDispatcher(config.GetConfigGlobal()); err != nil {
+			log.Printf("Webhook-Error: %v", err)
+		} else {
+			log.Printf("Webhooks enabled for %d endpoints", len(config.GetConfigGlobal().Webhooks))
+			lifecycle.Go("webhooks", func() { d.Run(config.SigShutdown) })
+		}
+	}
+
+	mux := http.NewServeMux()
+	ReloadHandler(&path, mux)
+
+	if config.GetConfigGlobal().RecAPI {
+		log.Println("Recordings API enabled on /api/cameras/{name}/recordings")
+		recordings.Register(mux, recordings.NewIndex(), streamer.Authorize)
+	}
+
+	if config.GetConfigGlobal().MetricsAPI {
+		log.Println("Metrics enabled on /metrics")
+		mux.Handle("GET /metrics", metrics.Handler())
+	}
+
+	if config.GetConfigGlobal().EventsAPI {
+		log.Println("Events enabled on /api/events")
+		mux.HandleFunc("GET /api/events", streamer.EventsHandler)
+	}
+
+	streamer.Routes.SetFallback(mux)
+	adminHandler := admin.Handler(func() error { return streamer.Reload(&path) })
+	if err = StartServers(config.GetConfigGlobal(), adminHandler); err != nil {
+		log.Fatalf("TLS-Error: %v", err)
+	}
+
+	<-done
+
+}
+
+func StartServers(cfg *config.ConfigGlobal, adminHandler http.Handler) error {
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", cfg.WShost, cfg.WSPort),
+		Handler: streamer.Routes,
+	}
+	secure := cfg.TLSCert != "" && cfg.TLSKey != ""
+	if secure {
+		reloader, err := certs.NewReloader(cfg.TLSCert, cfg.TLSKey, certs.CERT_CHECK_INTERVAL)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+		log.Printf("TLS enabled on %s", server.Addr)
+	}
+	list := []*http.Server{server}
+
+	if cfg.HTTPPort > 0 {
+		plain := &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", cfg.WShost, cfg.HTTPPort),
+			Handler: streamer.Routes,
+		}
+		if secure && cfg.HTTPRedirect {
+			plain.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				host, _, err := net.SplitHostPort(r.Host)
+				if err != nil {
+					host = r.Host
+				}
+				target := "https://" + net.JoinHostPort(host, strconv.Itoa(cfg.WSPort)) + r.URL.RequestURI()
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+			})
+			log.Printf("Redirecting %s to https", plain.Addr)
+		} else {
+			log.Printf("Plain listener on %s", plain.Addr)
+		}
+		list = append(list, plain)
+	}
+
+	if cfg.AdminPort > 0 {
+		adminServer := &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", cfg.AdminHost, cfg.AdminPort),
+			Handler: adminHandler,
+		}
+		log.Printf("Admin API on %s", adminServer.Addr)
+		list = append(list, adminServer)
+	}
+
+	// Hand the servers over to the shutdown handler, unless it already ran.
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	select {
+	case <-config.SigShutdown:
+		return nil
+	default:
+	}
+	servers = list
+
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
 			} else {
-				log.Printf("Failed to start streaming for %s", cfg.Name)
+				err = srv.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Println(err)
+				sigs <- syscall.SIGTERM
 			}
+		}(srv)
+	}
+	return nil
+}
+
+func SignURL(path *string, name string, ttl time.Duration) int {
+	if err := config.Init(path); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	secret, target, scope := config.GetConfigGlobal().AuthSecret, "/api/events", streamer.AUTH_SCOPE_EVENTS
+	if name != "events" {
+		cam := config.GetCamera(name)
+		if cam == nil {
+			fmt.Printf("Unknown camera %q\n", name)
+			return 1
 		}
+		if cam.AuthSecret != "" {
+			secret = cam.AuthSecret
+		}
+		name, target, scope = cam.Name, cam.WSPath, streamer.CameraScope(cam.Name)
+	}
+	if secret == "" {
+		fmt.Printf("No auth_secret configured for %q\n", name)
+		return 1
+	}
+	fmt.Printf("%s?%s\n", target, streamer.Sign(scope, secret, time.Now().Add(ttl)).Encode())
+	return 0
+}
+
+func CheckConfig(path *string) int {
+	if err := config.Init(path); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	issues := config.Validate()
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if issues.HasErrors() {
+		return 1
 	}
+	fmt.Println("Config ok.")
+	return 0
+}
+
+func ReloadHandler(path *string, mux *http.ServeMux) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	go func() {
-		cfg := config.GetConfigGlobal()
-		log.Println(http.ListenAndServe(fmt.Sprintf("%s:%d", cfg.WShost, cfg.WSPort), nil))
-		sigs <- syscall.SIGTERM
+		for range hup {
+			log.Println("SIGHUP received, reloading config...")
+			if err := streamer.Reload(path); err != nil {
+				log.Printf("Reload-Error: %v", err)
+			}
+		}
 	}()
 
-	<-done
-
+	if cfg := config.GetConfigGlobal(); cfg.ReloadAPI && cfg.AdminToken != "" {
+		log.Println("Reload API enabled on POST /api/reload")
+		mux.HandleFunc("POST /api/reload", func(w http.ResponseWriter, r *http.Request) {
+			token := config.GetConfigGlobal().AdminToken
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err := streamer.Reload(path); err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
 }
 
 func ShutdownHandler() {
@@ -64,7 +282,26 @@ func ShutdownHandler() {
 		log.Println("Shutting down bv-streamer...")
 		log.Println("Close all streamer...")
 		close(config.SigShutdown)
-		time.Sleep(3 * time.Second)
+
+		timeout := SHUTDOWN_TIMEOUT_DEFAULT
+		if cfg := config.GetConfigGlobal(); cfg != nil && cfg.ShutdownTimeout > 0 {
+			timeout = time.Duration(cfg.ShutdownTimeout) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		serversMu.Lock()
+		list := servers
+		serversMu.Unlock()
+		for _, srv := range list {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("HTTP server %s did not stop in time: %v", srv.Addr, err)
+			}
+		}
+		if pending := lifecycle.Wait(ctx); len(pending) > 0 {
+			log.Printf("Components failed to stop within %v: %s", timeout, strings.Join(pending, ", "))
+		}
+
 		log.Println("Goodbye!")
 		close(done)
 	}()
@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TYPE_COUNTER   = "counter"
+	TYPE_GAUGE     = "gauge"
+	TYPE_HISTOGRAM = "histogram"
+)
+
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mutex  sync.Mutex
+	values map[string]*series
+	fn     func() []Sample
+}
+
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+var (
+	mutex    sync.Mutex
+	families []*family
+)
+
+func register(f *family) *family {
+	f.values = make(map[string]*series)
+	mutex.Lock()
+	defer mutex.Unlock()
+	families = append(families, f)
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+	return f
+}
+
+func (f *family) get(labels []string) *series {
+	if len(labels) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d labels, got %d", f.name, len(f.labels), len(labels)))
+	}
+	key := strings.Join(labels, "\xff")
+	s := f.values[key]
+	if s == nil {
+		s = &series{labels: append([]string{}, labels...), counts: make([]uint64, len(f.buckets))}
+		f.values[key] = s
+	}
+	return s
+}
+
+type Counter struct{ f *family }
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{register(&family{name: name, help: help, typ: TYPE_COUNTER, labels: labels})}
+}
+
+func (c *Counter) Add(v float64, labels ...string) {
+	c.f.mutex.Lock()
+	defer c.f.mutex.Unlock()
+	c.f.get(labels).value += v
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+type Gauge struct{ f *family }
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{register(&family{name: name, help: help, typ: TYPE_GAUGE, labels: labels})}
+}
+
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+	g.f.get(labels).value = v
+}
+
+func (g *Gauge) Delete(labels ...string) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+	delete(g.f.values, strings.Join(labels, "\xff"))
+}
+
+func NewGaugeFunc(name, help string, fn func() []Sample, labels ...string) {
+	register(&family{name: name, help: help, typ: TYPE_GAUGE, labels: labels, fn: fn})
+}
+
+type Histogram struct{ f *family }
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{register(&family{name: name, help: help, typ: TYPE_HISTOGRAM, labels: labels, buckets: buckets})}
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.f.mutex.Lock()
+	defer h.f.mutex.Unlock()
+	s := h.f.get(labels)
+	for i, b := range h.f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+func WriteTo(w io.Writer) {
+	mutex.Lock()
+	list := append([]*family{}, families...)
+	mutex.Unlock()
+
+	for _, f := range list {
+		f.write(w)
+	}
+}
+
+func (f *family) write(w io.Writer) {
+	var samples []*series
+	if f.fn != nil {
+		for _, s := range f.fn() {
+			samples = append(samples, &series{labels: s.Labels, value: s.Value})
+		}
+	} else {
+		f.mutex.Lock()
+		for _, s := range f.values {
+			c := *s
+			c.counts = append([]uint64{}, s.counts...)
+			samples = append(samples, &c)
+		}
+		f.mutex.Unlock()
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].labels, "\xff") < strings.Join(samples[j].labels, "\xff")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range samples {
+		if f.typ != TYPE_HISTOGRAM {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, s.labels, "", ""), formatFloat(s.value))
+			continue
+		}
+		for i, b := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labels, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelString(f.labels, s.labels, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelString(f.labels, s.labels, "", ""), s.count)
+	}
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	var parts []string
+	for i, n := range names {
+		if i < len(values) {
+			parts = append(parts, fmt.Sprintf("%s=\"%s\"", n, escapeLabel(values[i])))
+		}
+	}
+	if extraName != "" {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,73 @@
+package metrics_test
+
+import (
+	"bv-streamer/metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const golden = `# HELP test_clients Connected clients.
+# TYPE test_clients gauge
+test_clients{camera="Garage"} 3
+test_clients{camera="Yard"} 0.5
+# HELP test_errors_total Errors per camera.
+# TYPE test_errors_total counter
+test_errors_total{camera="back\\slash",reason="quote\"d"} 1
+test_errors_total{camera="new\nline",reason="plain"} 2.5
+# HELP test_free_bytes Free bytes\\path
+# TYPE test_free_bytes gauge
+test_free_bytes{path="/rec"} 1.048576e+06
+# HELP test_restarts_total Restarts without labels.
+# TYPE test_restarts_total counter
+test_restarts_total 2
+# HELP test_write_seconds Write duration.\nSecond line.
+# TYPE test_write_seconds histogram
+test_write_seconds_bucket{camera="Garage",le="0.1"} 1
+test_write_seconds_bucket{camera="Garage",le="1"} 2
+test_write_seconds_bucket{camera="Garage",le="10"} 3
+test_write_seconds_bucket{camera="Garage",le="+Inf"} 4
+test_write_seconds_sum{camera="Garage"} 25.55
+test_write_seconds_count{camera="Garage"} 4
+`
+
+func TestExposition(t *testing.T) {
+	failures := metrics.NewCounter("test_errors_total", "Errors per camera.", "camera", "reason")
+	failures.Inc(`back\slash`, `quote"d`)
+	failures.Add(2.5, "new\nline", "plain")
+
+	restarts := metrics.NewCounter("test_restarts_total", "Restarts without labels.")
+	restarts.Inc()
+	restarts.Inc()
+
+	clients := metrics.NewGauge("test_clients", "Connected clients.", "camera")
+	clients.Set(0.5, "Yard")
+	clients.Set(3, "Garage")
+	clients.Set(7, "Gone")
+	clients.Delete("Gone")
+
+	metrics.NewGaugeFunc("test_free_bytes", `Free bytes\path`, func() []metrics.Sample {
+		return []metrics.Sample{{Labels: []string{"/rec"}, Value: 1 << 20}}
+	}, "path")
+
+	write := metrics.NewHistogram("test_write_seconds", "Write duration.\nSecond line.", []float64{10, 0.1, 1}, "camera")
+	for _, v := range []float64{0.05, 0.5, 5, 20} {
+		write.Observe(v, "Garage")
+	}
+
+	var out strings.Builder
+	metrics.WriteTo(&out)
+	if out.String() != golden {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), golden)
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if w.Body.String() != golden {
+		t.Errorf("handler output differs from WriteTo:\n%s", w.Body.String())
+	}
+}
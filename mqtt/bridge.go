@@ -0,0 +1,354 @@
+package mqtt
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/log"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MQTT_KEEPALIVE_DEFAULT = 30
+	MQTT_RECONNECT_MIN     = time.Second
+	MQTT_RECONNECT_MAX     = time.Minute
+	MQTT_EVENTS_BUFFER     = 256
+	MQTT_PREFIX_DEFAULT    = "bv-streamer"
+	MQTT_DISCOVERY_DEFAULT = "homeassistant"
+
+	STATE_ON  = "ON"
+	STATE_OFF = "OFF"
+)
+
+type sensor struct {
+	key   string
+	name  string
+	class string
+	icon  string
+}
+
+var sensors = []sensor{
+	{"motion", "Motion", "motion", ""},
+	{string(alarm.CLASS_PEOPLE), "Person", "occupancy", ""},
+	{string(alarm.CLASS_VEHICLE), "Vehicle", "", "mdi:car"},
+	{string(alarm.CLASS_DOG_CAT), "Animal", "", "mdi:paw"},
+	{string(alarm.CLASS_FACE), "Face", "", "mdi:face-recognition"},
+	{"recording", "Recording", "running", ""},
+}
+
+type Bridge struct {
+	cfg     *config.ConfigMQTT
+	cameras func() []*config.ConfigCamera
+	arm     func(camera string, armed bool) error
+
+	prefix string
+	node   string
+
+	names     map[string]string
+	state     map[string]string
+	discovery map[string][]byte
+}
+
+func NewBridge(cfg *config.ConfigMQTT, cameras func() []*config.ConfigCamera, arm func(camera string, armed bool) error) *Bridge {
+	prefix := strings.TrimSuffix(cfg.TopicPrefix, "/")
+	if prefix == "" {
+		prefix = MQTT_PREFIX_DEFAULT
+	}
+	return &Bridge{
+		cfg:       cfg,
+		cameras:   cameras,
+		arm:       arm,
+		prefix:    prefix,
+		node:      topicID(prefix),
+		names:     make(map[string]string),
+		state:     make(map[string]string),
+		discovery: make(map[string][]byte),
+	}
+}
+
+func (b *Bridge) Run(stop <-chan struct{}) {
+	evs, unsubscribe := events.Subscribe(MQTT_EVENTS_BUFFER)
+	defer unsubscribe()
+
+	for _, c := range b.cameras() {
+		if c.Tracking {
+			b.announce(c.Name)
+		}
+	}
+
+	backoff := MQTT_RECONNECT_MIN
+	for {
+		connected, err := b.session(evs, stop)
+		if err == nil {
+			return
+		}
+		if connected {
+			backoff = MQTT_RECONNECT_MIN
+		}
+		log.Warnf("[mqtt] %v, reconnecting in %v.", err, backoff)
+
+		timer := time.NewTimer(backoff)
+	wait:
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case ev := <-evs:
+				b.update(ev)
+			case <-timer.C:
+				break wait
+			}
+		}
+		backoff = min(backoff*2, MQTT_RECONNECT_MAX)
+	}
+}
+
+func (b *Bridge) session(evs <-chan events.Event, stop <-chan struct{}) (bool, error) {
+	opts, err := b.options()
+	if err != nil {
+		return false, err
+	}
+	client, err := Dial(opts)
+	if err != nil {
+		return false, err
+	}
+	defer client.Disconnect()
+	log.Infof("[mqtt] Connected to %s.", b.cfg.Broker)
+
+	cmds := make(chan Message, MQTT_INBOX)
+	err = client.Subscribe(b.prefix+"/+/armed/set", b.cfg.QoS, func(m Message) {
+		select {
+		case cmds <- m:
+		default:
+			log.Warnf("[mqtt] Command on %s dropped.", m.Topic)
+		}
+	})
+	if err != nil {
+		return true, err
+	}
+
+	if err := b.publish(client, b.availability(), []byte("online")); err != nil {
+		return true, err
+	}
+	for _, topic := range sortedKeys(b.discovery) {
+		if err := b.publish(client, topic, b.discovery[topic]); err != nil {
+			return true, err
+		}
+	}
+	for _, topic := range sortedKeys(b.state) {
+		if err := b.publish(client, topic, []byte(b.state[topic])); err != nil {
+			return true, err
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			b.publish(client, b.availability(), []byte("offline"))
+			return true, nil
+		case <-client.Done():
+			return true, client.Err()
+		case ev := <-evs:
+			for _, m := range b.update(ev) {
+				if err := client.Publish(m); err != nil {
+					return true, err
+				}
+			}
+		case m := <-cmds:
+			b.command(m)
+		}
+	}
+}
+
+func (b *Bridge) options() (Options, error) {
+	keepAlive := b.cfg.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = MQTT_KEEPALIVE_DEFAULT
+	}
+	opts := Options{
+		Broker:    b.cfg.Broker,
+		ClientID:  b.cfg.ClientID,
+		User:      b.cfg.User,
+		Pass:      b.cfg.Pass,
+		KeepAlive: time.Duration(keepAlive) * time.Second,
+		Will:      &Message{Topic: b.availability(), Payload: []byte("offline"), QoS: b.cfg.QoS, Retain: true},
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = MQTT_PREFIX_DEFAULT
+	}
+
+	if b.cfg.TLSCA != "" || b.cfg.TLSInsecure {
+		opts.TLS = &tls.Config{InsecureSkipVerify: b.cfg.TLSInsecure}
+		if b.cfg.TLSCA != "" {
+			pem, err := os.ReadFile(b.cfg.TLSCA)
+			if err != nil {
+				return opts, err
+			}
+			opts.TLS.RootCAs = x509.NewCertPool()
+			if !opts.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return opts, fmt.Errorf("no certificates found in %s", b.cfg.TLSCA)
+			}
+		}
+	}
+	return opts, nil
+}
+
+func (b *Bridge) publish(client *Client, topic string, payload []byte) error {
+	return client.Publish(Message{Topic: topic, Payload: payload, QoS: b.cfg.QoS, Retain: true})
+}
+
+func (b *Bridge) availability() string {
+	return b.prefix + "/status"
+}
+
+func (b *Bridge) topic(camera, key string) string {
+	return b.prefix + "/" + topicID(camera) + "/" + key
+}
+
+func (b *Bridge) announce(camera string) []Message {
+	id := topicID(camera)
+	if _, found := b.names[id]; found {
+		return nil
+	}
+	b.names[id] = camera
+
+	var msgs []Message
+	for _, s := range sensors {
+		msgs = append(msgs, b.set(camera, s.key, STATE_OFF)...)
+	}
+	msgs = append(msgs, b.set(camera, "armed", STATE_ON)...)
+	if !b.cfg.Discovery {
+		return msgs
+	}
+
+	prefix := strings.TrimSuffix(b.cfg.DiscoveryPrefix, "/")
+	if prefix == "" {
+		prefix = MQTT_DISCOVERY_DEFAULT
+	}
+	device := discoveryDevice{
+		Identifiers:  []string{b.node + "_" + id},
+		Name:         camera,
+		Manufacturer: "bv-streamer",
+		Model:        "IP camera",
+	}
+	entity := func(component, key, name, class, icon, command string) {
+		payload, _ := json.Marshal(discoveryConfig{
+			Name:              name,
+			UniqueID:          b.node + "_" + id + "_" + key,
+			StateTopic:        b.topic(camera, key),
+			CommandTopic:      command,
+			AvailabilityTopic: b.availability(),
+			PayloadOn:         STATE_ON,
+			PayloadOff:        STATE_OFF,
+			DeviceClass:       class,
+			Icon:              icon,
+			Device:            device,
+		})
+		topic := fmt.Sprintf("%s/%s/%s/%s_%s/config", prefix, component, b.node, id, key)
+		b.discovery[topic] = payload
+		msgs = append(msgs, Message{Topic: topic, Payload: payload, QoS: b.cfg.QoS, Retain: true})
+	}
+	for _, s := range sensors {
+		entity("binary_sensor", s.key, s.name, s.class, s.icon, "")
+	}
+	entity("switch", "armed", "Armed", "", "mdi:shield-check", b.topic(camera, "armed/set"))
+	return msgs
+}
+
+func (b *Bridge) set(camera, key, value string) []Message {
+	topic := b.topic(camera, key)
+	if b.state[topic] == value {
+		return nil
+	}
+	b.state[topic] = value
+	return []Message{{Topic: topic, Payload: []byte(value), QoS: b.cfg.QoS, Retain: true}}
+}
+
+func (b *Bridge) update(ev events.Event) []Message {
+	var msgs []Message
+	switch ev.Type {
+	case events.EVENT_MOTION_START, events.EVENT_MOTION_STOP, events.EVENT_DETECTION, events.EVENT_ALARM_STOP,
+		events.EVENT_REC_START, events.EVENT_REC_STOP, events.EVENT_ARMED, events.EVENT_DISARMED:
+		msgs = b.announce(ev.Camera)
+	default:
+		return nil
+	}
+
+	switch ev.Type {
+	case events.EVENT_MOTION_START:
+		msgs = append(msgs, b.set(ev.Camera, "motion", STATE_ON)...)
+	case events.EVENT_MOTION_STOP:
+		msgs = append(msgs, b.set(ev.Camera, "motion", STATE_OFF)...)
+	case events.EVENT_DETECTION:
+		classes, _ := ev.Data["classes"].([]alarm.Class)
+		for _, c := range classes {
+			msgs = append(msgs, b.set(ev.Camera, string(c), STATE_ON)...)
+		}
+	case events.EVENT_ALARM_STOP:
+		for _, s := range sensors {
+			if s.key != "motion" && s.key != "recording" {
+				msgs = append(msgs, b.set(ev.Camera, s.key, STATE_OFF)...)
+			}
+		}
+	case events.EVENT_REC_START:
+		msgs = append(msgs, b.set(ev.Camera, "recording", STATE_ON)...)
+	case events.EVENT_REC_STOP:
+		msgs = append(msgs, b.set(ev.Camera, "recording", STATE_OFF)...)
+	case events.EVENT_ARMED:
+		msgs = append(msgs, b.set(ev.Camera, "armed", STATE_ON)...)
+	case events.EVENT_DISARMED:
+		msgs = append(msgs, b.set(ev.Camera, "armed", STATE_OFF)...)
+	}
+	return msgs
+}
+
+func (b *Bridge) command(m Message) {
+	id := strings.TrimSuffix(strings.TrimPrefix(m.Topic, b.prefix+"/"), "/armed/set")
+	camera, found := b.names[id]
+	if !found {
+		log.Warnf("[mqtt] Command for unknown camera on %s.", m.Topic)
+		return
+	}
+
+	var armed bool
+	switch strings.ToUpper(strings.TrimSpace(string(m.Payload))) {
+	case STATE_ON, "ARM":
+		armed = true
+	case STATE_OFF, "DISARM":
+	default:
+		log.Warnf("[%s] Unknown MQTT command %q.", camera, m.Payload)
+		return
+	}
+	if err := b.arm(camera, armed); err != nil {
+		log.Warnf("[%s] MQTT command %q failed: %v", camera, m.Payload, err)
+	}
+}
+
+func topicID(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, name)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,397 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MQTT_TIMEOUT_DEFAULT = 10 * time.Second
+	MQTT_INBOX           = 64
+)
+
+var ErrClosed = errors.New("mqtt: connection closed")
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+type Handler func(Message)
+
+type Options struct {
+	Broker    string
+	ClientID  string
+	User      string
+	Pass      string
+	KeepAlive time.Duration
+	Timeout   time.Duration
+	TLS       *tls.Config
+	Will      *Message
+}
+
+type Client struct {
+	opts Options
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	nextID   uint16
+	acks     map[uint16]chan []byte
+	handlers map[string]Handler
+
+	inbox     chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func Dial(opts Options) (*Client, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = MQTT_TIMEOUT_DEFAULT
+	}
+
+	u, err := url.Parse(opts.Broker)
+	if err != nil {
+		return nil, err
+	}
+	secure := false
+	switch strings.ToLower(u.Scheme) {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		secure = true
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "1883"
+		if secure {
+			port = "8883"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	var conn net.Conn
+	if secure {
+		cfg := opts.TLS
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = u.Hostname()
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, cfg)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		opts:     opts,
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		acks:     make(map[uint16]chan []byte),
+		handlers: make(map[string]Handler),
+		inbox:    make(chan Message, MQTT_INBOX),
+		done:     make(chan struct{}),
+	}
+	if err := c.connect(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.reader()
+	go c.dispatcher()
+	if opts.KeepAlive > 0 {
+		go c.pinger()
+	}
+	return c, nil
+}
+
+func (c *Client) connect() error {
+	var flags byte = 0x02
+	body := appendString(nil, "MQTT")
+	body = append(body, 4)
+	if w := c.opts.Will; w != nil {
+		flags |= 0x04 | (w.QoS&0x03)<<3
+		if w.Retain {
+			flags |= 0x20
+		}
+	}
+	if c.opts.User != "" {
+		flags |= 0x80
+		if c.opts.Pass != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(c.opts.KeepAlive/time.Second))
+	body = appendString(body, c.opts.ClientID)
+	if w := c.opts.Will; w != nil {
+		body = appendString(body, w.Topic)
+		body = appendString(body, string(w.Payload))
+	}
+	if c.opts.User != "" {
+		body = appendString(body, c.opts.User)
+		if c.opts.Pass != "" {
+			body = appendString(body, c.opts.Pass)
+		}
+	}
+
+	c.conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := WritePacket(c.conn, Packet{Type: CONNECT, Body: body}); err != nil {
+		return err
+	}
+	p, err := ReadPacket(c.r)
+	if err != nil {
+		return err
+	}
+	if p.Type != CONNACK || len(p.Body) != 2 {
+		return fmt.Errorf("mqtt: expected CONNACK, got packet type %d", p.Type)
+	}
+	if code := p.Body[1]; code != 0 {
+		if msg, found := connackErrors[code]; found {
+			return fmt.Errorf("mqtt: connection refused: %s", msg)
+		}
+		return fmt.Errorf("mqtt: connection refused with code %d", code)
+	}
+	return nil
+}
+
+func (c *Client) Publish(m Message) error {
+	if m.QoS == 0 {
+		return c.write(EncodePublish(m, 0))
+	}
+	m.QoS = 1
+
+	id, ack := c.register()
+	defer c.unregister(id)
+	if err := c.write(EncodePublish(m, id)); err != nil {
+		return err
+	}
+	_, err := c.wait(ack, "PUBACK")
+	return err
+}
+
+func (c *Client) Subscribe(filter string, qos byte, h Handler) error {
+	if qos > 1 {
+		qos = 1
+	}
+
+	c.mu.Lock()
+	c.handlers[filter] = h
+	c.mu.Unlock()
+
+	id, ack := c.register()
+	defer c.unregister(id)
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = append(appendString(body, filter), qos)
+	if err := c.write(Packet{Type: SUBSCRIBE, Flags: 0x02, Body: body}); err != nil {
+		return err
+	}
+
+	codes, err := c.wait(ack, "SUBACK")
+	if err != nil {
+		return err
+	}
+	if len(codes) != 1 || codes[0] == 0x80 {
+		return fmt.Errorf("mqtt: subscription to %q rejected", filter)
+	}
+	return nil
+}
+
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) Err() error {
+	<-c.done
+	return c.err
+}
+
+func (c *Client) Disconnect() {
+	c.write(Packet{Type: DISCONNECT})
+	c.close(ErrClosed)
+}
+
+func (c *Client) close(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+func (c *Client) write(p Packet) error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	if err := WritePacket(c.conn, p); err != nil {
+		c.close(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) register() (uint16, chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		c.nextID++
+		if _, found := c.acks[c.nextID]; c.nextID != 0 && !found {
+			break
+		}
+	}
+	ack := make(chan []byte, 1)
+	c.acks[c.nextID] = ack
+	return c.nextID, ack
+}
+
+func (c *Client) unregister(id uint16) {
+	c.mu.Lock()
+	delete(c.acks, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) wait(ack chan []byte, kind string) ([]byte, error) {
+	select {
+	case body := <-ack:
+		return body, nil
+	case <-c.done:
+		return nil, c.err
+	case <-time.After(c.opts.Timeout):
+		return nil, fmt.Errorf("mqtt: no %s within %v", kind, c.opts.Timeout)
+	}
+}
+
+func (c *Client) reader() {
+	for {
+		if c.opts.KeepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive * 3 / 2))
+		}
+		p, err := ReadPacket(c.r)
+		if err != nil {
+			c.close(err)
+			return
+		}
+
+		switch p.Type {
+		case PUBLISH:
+			m, id, err := DecodePublish(p)
+			if err != nil {
+				c.close(err)
+				return
+			}
+			if m.QoS == 1 {
+				c.write(Packet{Type: PUBACK, Body: binary.BigEndian.AppendUint16(nil, id)})
+			}
+			select {
+			case c.inbox <- m:
+			case <-c.done:
+				return
+			}
+		case PUBACK, SUBACK:
+			id, rest, err := readID(p.Body)
+			if err != nil {
+				c.close(err)
+				return
+			}
+			c.mu.Lock()
+			if ack, found := c.acks[id]; found {
+				select {
+				case ack <- rest:
+				default:
+				}
+			}
+			c.mu.Unlock()
+		case PINGRESP:
+		default:
+			c.close(fmt.Errorf("mqtt: unexpected packet type %d", p.Type))
+			return
+		}
+	}
+}
+
+func (c *Client) dispatcher() {
+	for {
+		select {
+		case m := <-c.inbox:
+			c.mu.Lock()
+			var handlers []Handler
+			for filter, h := range c.handlers {
+				if Match(filter, m.Topic) {
+					handlers = append(handlers, h)
+				}
+			}
+			c.mu.Unlock()
+			for _, h := range handlers {
+				h(m)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) pinger() {
+	ticker := time.NewTicker(c.opts.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if c.write(Packet{Type: PINGREQ}) != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func Match(filter, topic string) bool {
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		switch {
+		case level == "#":
+			return true
+		case i >= len(t):
+			return false
+		case level != "+" && level != t[i]:
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
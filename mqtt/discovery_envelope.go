@@ -0,0 +1,21 @@
+package mqtt
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	CommandTopic      string          `json:"command_topic,omitempty"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	PayloadOn         string          `json:"payload_on"`
+	PayloadOff        string          `json:"payload_off"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	Icon              string          `json:"icon,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
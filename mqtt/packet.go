@@ -0,0 +1,135 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	CONNECT    byte = 1
+	CONNACK    byte = 2
+	PUBLISH    byte = 3
+	PUBACK     byte = 4
+	SUBSCRIBE  byte = 8
+	SUBACK     byte = 9
+	PINGREQ    byte = 12
+	PINGRESP   byte = 13
+	DISCONNECT byte = 14
+)
+
+const MAX_PACKET_SIZE = 268435455
+
+var ErrMalformed = errors.New("mqtt: malformed packet")
+
+type Packet struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+func ReadPacket(r *bufio.Reader) (Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return Packet{}, err
+	}
+
+	var size, shift int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return Packet{}, err
+		}
+		size |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return Packet{}, ErrMalformed
+		}
+	}
+
+	p := Packet{Type: header >> 4, Flags: header & 0x0f, Body: make([]byte, size)}
+	if _, err := io.ReadFull(r, p.Body); err != nil {
+		return Packet{}, err
+	}
+	return p, nil
+}
+
+func WritePacket(w io.Writer, p Packet) error {
+	if len(p.Body) > MAX_PACKET_SIZE {
+		return fmt.Errorf("mqtt: packet of %d bytes too large", len(p.Body))
+	}
+
+	buf := make([]byte, 0, len(p.Body)+5)
+	buf = append(buf, p.Type<<4|p.Flags&0x0f)
+	size := len(p.Body)
+	for {
+		b := byte(size & 0x7f)
+		if size >>= 7; size > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if size == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(buf, p.Body...))
+	return err
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint16(b, uint16(len(s))), s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, ErrMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, ErrMalformed
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func readID(b []byte) (uint16, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, ErrMalformed
+	}
+	return binary.BigEndian.Uint16(b), b[2:], nil
+}
+
+func EncodePublish(m Message, id uint16) Packet {
+	var flags byte
+	if m.Retain {
+		flags |= 0x01
+	}
+	flags |= (m.QoS & 0x03) << 1
+
+	body := appendString(nil, m.Topic)
+	if m.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return Packet{Type: PUBLISH, Flags: flags, Body: append(body, m.Payload...)}
+}
+
+func DecodePublish(p Packet) (Message, uint16, error) {
+	m := Message{Retain: p.Flags&0x01 != 0, QoS: p.Flags >> 1 & 0x03}
+	topic, rest, err := readString(p.Body)
+	if err != nil {
+		return m, 0, err
+	}
+	m.Topic = topic
+
+	var id uint16
+	if m.QoS > 0 {
+		if id, rest, err = readID(rest); err != nil {
+			return m, 0, err
+		}
+	}
+	m.Payload = append([]byte{}, rest...)
+	return m, id, nil
+}
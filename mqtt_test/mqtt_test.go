@@ -0,0 +1,286 @@
+package mqtt_test
+
+import (
+	"bufio"
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/mqtt"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type brokerStandIn struct {
+	ln net.Listener
+
+	mu           sync.Mutex
+	conn         net.Conn
+	connect      []byte
+	subs         []string
+	published    []mqtt.Message
+	retained     map[string]string
+	disconnected bool
+}
+
+func newBrokerStandIn(t *testing.T) *brokerStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &brokerStandIn{ln: ln, retained: make(map[string]string)}
+	t.Cleanup(func() { ln.Close() })
+	go b.accept()
+	return b
+}
+
+func (b *brokerStandIn) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *brokerStandIn) accept() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conn = conn
+		b.mu.Unlock()
+		go b.serve(conn)
+	}
+}
+
+func (b *brokerStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		p, err := mqtt.ReadPacket(r)
+		if err != nil {
+			return
+		}
+
+		b.mu.Lock()
+		switch p.Type {
+		case mqtt.CONNECT:
+			b.connect = p.Body
+			mqtt.WritePacket(conn, mqtt.Packet{Type: mqtt.CONNACK, Body: []byte{0, 0}})
+		case mqtt.PUBLISH:
+			m, id, _ := mqtt.DecodePublish(p)
+			b.published = append(b.published, m)
+			if m.Retain {
+				b.retained[m.Topic] = string(m.Payload)
+			}
+			if m.QoS == 1 {
+				mqtt.WritePacket(conn, mqtt.Packet{Type: mqtt.PUBACK, Body: binary.BigEndian.AppendUint16(nil, id)})
+			}
+		case mqtt.PUBACK:
+		case mqtt.SUBSCRIBE:
+			n := int(binary.BigEndian.Uint16(p.Body[2:]))
+			b.subs = append(b.subs, string(p.Body[4:4+n]))
+			mqtt.WritePacket(conn, mqtt.Packet{Type: mqtt.SUBACK, Body: append(p.Body[:2:2], p.Body[4+n])})
+		case mqtt.PINGREQ:
+			mqtt.WritePacket(conn, mqtt.Packet{Type: mqtt.PINGRESP})
+		case mqtt.DISCONNECT:
+			b.disconnected = true
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *brokerStandIn) send(m mqtt.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	mqtt.WritePacket(b.conn, mqtt.EncodePublish(m, 42))
+}
+
+func (b *brokerStandIn) state(topic string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retained[topic]
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func initConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bv-streamer.conf")
+	if err := os.WriteFile(path, []byte(`{"loglevel": "error", "ws_port": 1510}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Init(&path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	broker := newBrokerStandIn(t)
+
+	client, err := mqtt.Dial(mqtt.Options{
+		Broker:   broker.url(),
+		ClientID: "test",
+		User:     "user",
+		Pass:     "pass",
+		Will:     &mqtt.Message{Topic: "bv/status", Payload: []byte("offline"), QoS: 1, Retain: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	broker.mu.Lock()
+	connect := broker.connect
+	broker.mu.Unlock()
+	if string(connect[2:6]) != "MQTT" || connect[6] != 4 {
+		t.Fatalf("unexpected protocol header % x", connect[:7])
+	}
+	if connect[7] != 0xee {
+		t.Errorf("connect flags = %#x, want 0xee", connect[7])
+	}
+
+	if err := client.Publish(mqtt.Message{Topic: "bv/a", Payload: []byte("ON"), QoS: 1, Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := broker.state("bv/a"); got != "ON" {
+		t.Errorf("retained bv/a = %q, want ON", got)
+	}
+
+	received := make(chan mqtt.Message, 1)
+	if err := client.Subscribe("bv/+/set", 1, func(m mqtt.Message) { received <- m }); err != nil {
+		t.Fatal(err)
+	}
+	broker.send(mqtt.Message{Topic: "bv/other/state", Payload: []byte("ignored")})
+	broker.send(mqtt.Message{Topic: "bv/a/set", Payload: []byte("OFF"), QoS: 1})
+	select {
+	case m := <-received:
+		if m.Topic != "bv/a/set" || string(m.Payload) != "OFF" {
+			t.Errorf("received %s %q", m.Topic, m.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/#", "a/b/c", true},
+		{"a/+", "a/b/c", false},
+		{"a/b/c", "a/b", false},
+	}
+	for _, c := range cases {
+		if got := mqtt.Match(c.filter, c.topic); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.filter, c.topic, got, c.want)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	initConfig(t)
+	broker := newBrokerStandIn(t)
+
+	cfg := &config.ConfigMQTT{Broker: broker.url(), QoS: 1, TopicPrefix: "bv", Discovery: true}
+	cameras := []*config.ConfigCamera{{Name: "Garage Cam", Tracking: true}}
+
+	var mu sync.Mutex
+	var commands []bool
+	bridge := mqtt.NewBridge(cfg, func() []*config.ConfigCamera { return cameras }, func(camera string, armed bool) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if camera == "Garage Cam" {
+			commands = append(commands, armed)
+		}
+		return nil
+	})
+
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		bridge.Run(stop)
+		close(finished)
+	}()
+
+	waitFor(t, "online", func() bool { return broker.state("bv/status") == "online" })
+	waitFor(t, "initial state", func() bool { return broker.state("bv/garage_cam/armed") == "ON" })
+	if got := broker.state("bv/garage_cam/motion"); got != "OFF" {
+		t.Errorf("motion = %q, want OFF", got)
+	}
+
+	var disc struct {
+		StateTopic   string `json:"state_topic"`
+		CommandTopic string `json:"command_topic"`
+		DeviceClass  string `json:"device_class"`
+	}
+	if err := json.Unmarshal([]byte(broker.state("homeassistant/binary_sensor/bv/garage_cam_people/config")), &disc); err != nil {
+		t.Fatal(err)
+	}
+	if disc.StateTopic != "bv/garage_cam/people" || disc.DeviceClass != "occupancy" {
+		t.Errorf("unexpected people discovery %+v", disc)
+	}
+	if err := json.Unmarshal([]byte(broker.state("homeassistant/switch/bv/garage_cam_armed/config")), &disc); err != nil {
+		t.Fatal(err)
+	}
+	if disc.CommandTopic != "bv/garage_cam/armed/set" {
+		t.Errorf("armed command topic = %q", disc.CommandTopic)
+	}
+
+	events.Publish(events.EVENT_MOTION_START, "Garage Cam", nil)
+	events.Publish(events.EVENT_DETECTION, "Garage Cam", map[string]any{"classes": []alarm.Class{alarm.CLASS_PEOPLE}})
+	events.Publish(events.EVENT_REC_START, "Garage Cam", map[string]any{"id": "x"})
+	waitFor(t, "detection", func() bool {
+		return broker.state("bv/garage_cam/motion") == "ON" && broker.state("bv/garage_cam/people") == "ON" &&
+			broker.state("bv/garage_cam/recording") == "ON"
+	})
+
+	events.Publish(events.EVENT_ALARM_STOP, "Garage Cam", nil)
+	waitFor(t, "alarm stop", func() bool { return broker.state("bv/garage_cam/people") == "OFF" })
+
+	waitFor(t, "command subscription", func() bool {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		return len(broker.subs) == 1 && broker.subs[0] == "bv/+/armed/set"
+	})
+	broker.send(mqtt.Message{Topic: "bv/garage_cam/armed/set", Payload: []byte("OFF"), QoS: 1})
+	broker.send(mqtt.Message{Topic: "bv/garage_cam/armed/set", Payload: []byte("arm")})
+	waitFor(t, "commands", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(commands) == 2 && !commands[0] && commands[1]
+	})
+
+	events.Publish(events.EVENT_DISARMED, "Garage Cam", nil)
+	waitFor(t, "disarmed", func() bool { return broker.state("bv/garage_cam/armed") == "OFF" })
+
+	close(stop)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bridge did not stop")
+	}
+	if got := broker.state("bv/status"); got != "offline" {
+		t.Errorf("status after stop = %q, want offline", got)
+	}
+	waitFor(t, "disconnect", func() bool {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		return broker.disconnected
+	})
+}
@@ -0,0 +1,102 @@
+package recordings
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+func Register(mux *http.ServeMux, idx *Index, authorize func(http.ResponseWriter, *http.Request, string) bool) {
+	guard := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if authorize(w, r, r.PathValue("name")) {
+				h(w, r)
+			}
+		}
+	}
+	mux.HandleFunc("GET /api/cameras/{name}/recordings", guard(idx.handleList))
+	mux.HandleFunc("GET /api/cameras/{name}/recordings/{id}", guard(idx.handleGet))
+}
+
+func (idx *Index) handleList(w http.ResponseWriter, r *http.Request) {
+	cfg := config.GetCamera(r.PathValue("name"))
+	if cfg == nil {
+		http.Error(w, "Unknown camera", http.StatusNotFound)
+		return
+	}
+
+	from, err := parseTime(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTime(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list := idx.List(cfg, from, to)
+	if list == nil {
+		list = []*Recording{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (idx *Index) handleGet(w http.ResponseWriter, r *http.Request) {
+	cfg := config.GetCamera(r.PathValue("name"))
+	if cfg == nil {
+		http.Error(w, "Unknown camera", http.StatusNotFound)
+		return
+	}
+	rec := idx.Get(cfg, r.PathValue("id"))
+	if rec == nil {
+		http.Error(w, "Unknown recording", http.StatusNotFound)
+		return
+	}
+	path, format := rec.Path(r.URL.Query().Get("format"))
+	if path == "" {
+		http.Error(w, "Format not available", http.StatusNotFound)
+		return
+	}
+
+	defer alarm.Hold(path)()
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Recording not available", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Recording not available", http.StatusInternalServerError)
+		return
+	}
+
+	name := rec.ID + "." + format
+	switch format {
+	case "mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	case "ts":
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,213 @@
+package recordings
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/log"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KIND_CLIP    string = "clip"
+	KIND_ARCHIVE string = "archive"
+
+	rescanInterval time.Duration = 10 * time.Second
+)
+
+type Recording struct {
+	ID      string           `json:"id"`
+	Camera  string           `json:"camera"`
+	Kind    string           `json:"kind"`
+	Start   time.Time        `json:"start"`
+	End     time.Time        `json:"end"`
+	Classes []alarm.Class    `json:"classes,omitempty"`
+	Files   map[string]int64 `json:"files"`
+
+	paths map[string]string
+}
+
+func (r *Recording) Path(format string) (string, string) {
+	if format != "" {
+		return r.paths[format], format
+	}
+	for _, f := range []string{"mp4", "ts"} {
+		if p, ok := r.paths[f]; ok {
+			return p, f
+		}
+	}
+	return "", ""
+}
+
+type camIndex struct {
+	scanned    time.Time
+	recordings []*Recording
+}
+
+type Index struct {
+	mutex   sync.Mutex
+	cameras map[string]*camIndex
+}
+
+func NewIndex() *Index {
+	return &Index{cameras: make(map[string]*camIndex)}
+}
+
+func (idx *Index) List(cfg *config.ConfigCamera, from, to time.Time) []*Recording {
+	var list []*Recording
+	for _, r := range idx.recordings(cfg) {
+		if !from.IsZero() && r.End.Before(from) {
+			continue
+		}
+		if !to.IsZero() && r.Start.After(to) {
+			continue
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+func (idx *Index) Get(cfg *config.ConfigCamera, id string) *Recording {
+	for _, r := range idx.recordings(cfg) {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (idx *Index) recordings(cfg *config.ConfigCamera) []*Recording {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	ci, found := idx.cameras[cfg.Name]
+	if !found || time.Since(ci.scanned) > rescanInterval {
+		ci = &camIndex{scanned: time.Now(), recordings: scan(cfg)}
+		idx.cameras[cfg.Name] = ci
+	}
+	return ci.recordings
+}
+
+func scan(cfg *config.ConfigCamera) []*Recording {
+	byID := make(map[string]*Recording)
+
+	prefix := "rec_" + cfg.Name + "_"
+	if entries, err := os.ReadDir(cfg.RecPath); err == nil {
+		for _, e := range entries {
+			name := e.Name()
+			ext := strings.TrimPrefix(filepath.Ext(name), ".")
+			id := strings.TrimSuffix(name, filepath.Ext(name))
+			// An mp4 only appears under its final name once the remux is
+			// done, until then the .ts is served.
+			if e.IsDir() || !strings.HasPrefix(name, prefix) || (ext != "ts" && ext != "mp4") {
+				continue
+			}
+			unix, err := strconv.ParseInt(strings.TrimPrefix(id, prefix), 10, 64)
+			if err != nil {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			r := byID[id]
+			if r == nil {
+				r = &Recording{
+					ID:     id,
+					Camera: cfg.Name,
+					Kind:   KIND_CLIP,
+					Start:  time.Unix(unix, 0),
+					Files:  make(map[string]int64),
+					paths:  make(map[string]string),
+				}
+				byID[id] = r
+			}
+			r.Files[ext] = info.Size()
+			r.paths[ext] = filepath.Join(cfg.RecPath, name)
+			if ext == "ts" || r.End.IsZero() {
+				r.End = info.ModTime()
+			}
+		}
+	} else {
+		log.Errorf("[%s] %v", cfg.Name, err)
+	}
+
+	for _, r := range byID {
+		data, err := os.ReadFile(filepath.Join(cfg.RecPath, r.ID+".json"))
+		if err != nil {
+			continue
+		}
+		var meta alarm.RecMetaEnvelope
+		if err := json.Unmarshal(data, &meta); err == nil {
+			r.Classes = meta.Classes
+			if meta.End > 0 {
+				r.End = time.Unix(meta.End, 0)
+			}
+		}
+	}
+
+	arcPath := filepath.Join(cfg.RecPath, "archive")
+	prefix = cfg.Name + "_"
+	if entries, err := os.ReadDir(arcPath); err == nil {
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".mp4") {
+				continue
+			}
+			id := strings.TrimSuffix(name, ".mp4")
+			day, err := time.ParseInLocation(alarm.DATE_FORMAT, strings.TrimPrefix(id, prefix), time.Local)
+			if err != nil {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			r := &Recording{
+				ID:     id,
+				Camera: cfg.Name,
+				Kind:   KIND_ARCHIVE,
+				Start:  day,
+				End:    day.AddDate(0, 0, 1),
+				Files:  map[string]int64{"mp4": info.Size()},
+				paths:  map[string]string{"mp4": filepath.Join(arcPath, name)},
+			}
+			if data, err := os.ReadFile(filepath.Join(arcPath, id+".json")); err == nil {
+				var metas []alarm.RecMetaEnvelope
+				if err := json.Unmarshal(data, &metas); err == nil {
+					r.Classes = mergeClasses(metas)
+				}
+			}
+			byID[id] = r
+		}
+	}
+
+	list := make([]*Recording, 0, len(byID))
+	for _, r := range byID {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Start.Before(list[b].Start)
+	})
+	return list
+}
+
+func mergeClasses(metas []alarm.RecMetaEnvelope) []alarm.Class {
+	var classes []alarm.Class
+	seen := make(map[alarm.Class]bool)
+	for _, m := range metas {
+		for _, c := range m.Classes {
+			if !seen[c] {
+				seen[c] = true
+				classes = append(classes, c)
+			}
+		}
+	}
+	return classes
+}
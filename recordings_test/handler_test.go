@@ -0,0 +1,99 @@
+package recordings_test
+
+import (
+	"bv-streamer/recordings"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	_, recPath := initConfig(t)
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	id := fmt.Sprintf("rec_Garage_%d", start.Unix())
+	ts := strings.Repeat("0123456789", 100)
+	writeFile(t, filepath.Join(recPath, id+".ts"), ts, start.Add(time.Minute))
+
+	mux := http.NewServeMux()
+	authorized := true
+	recordings.Register(mux, recordings.NewIndex(), func(w http.ResponseWriter, r *http.Request, name string) bool {
+		if !authorized {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+		return authorized
+	})
+	get := func(path string, header ...string) *http.Response {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	resp := get("/api/cameras/garage/recordings")
+	var list []recordings.Recording
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("list: %d %v", resp.StatusCode, err)
+	}
+	if len(list) != 1 || list[0].ID != id {
+		t.Fatalf("unexpected list %+v", list)
+	}
+
+	resp = get("/api/cameras/Garage/recordings/"+id, "Range", "bytes=10-19")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != ts[10:20] {
+		t.Errorf("range: got %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 10-19/1000" {
+		t.Errorf("Content-Range %q", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "video/mp2t" {
+		t.Errorf("Content-Type %q", got)
+	}
+
+	resp = get("/api/cameras/Garage/recordings/"+id, "Range", "bytes=990-")
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusPartialContent || string(body) != ts[990:] {
+		t.Errorf("open range: got %d %q", resp.StatusCode, body)
+	}
+	resp = get("/api/cameras/Garage/recordings/"+id, "Range", "bytes=2000-")
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("range past the end: got %d", resp.StatusCode)
+	}
+
+	resp = get("/api/cameras/Garage/recordings/" + id + "?download=1")
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != ts {
+		t.Errorf("download: got %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename="`+id+`.ts"` {
+		t.Errorf("Content-Disposition %q", got)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/api/cameras/Garage/recordings/" + id + "?format=mp4", http.StatusNotFound},
+		{"/api/cameras/Garage/recordings/rec_Garage_1", http.StatusNotFound},
+		{"/api/cameras/Nope/recordings/" + id, http.StatusNotFound},
+		{"/api/cameras/Nope/recordings", http.StatusNotFound},
+		{"/api/cameras/Garage/recordings?from=yesterday", http.StatusBadRequest},
+	} {
+		if resp := get(tt.path); resp.StatusCode != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+
+	authorized = false
+	if resp := get("/api/cameras/Garage/recordings/" + id); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthorized: got %d", resp.StatusCode)
+	}
+}
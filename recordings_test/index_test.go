@@ -0,0 +1,121 @@
+package recordings_test
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/recordings"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func initConfig(t *testing.T) (*config.ConfigCamera, string) {
+	t.Helper()
+	dir := t.TempDir()
+	recPath := filepath.Join(dir, "rec")
+	if err := os.MkdirAll(filepath.Join(recPath, "archive"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "bv-streamer.conf")
+	conf := fmt.Sprintf(`{
+  "loglevel": "error",
+  "ws_port": 1510,
+  "cameras": [{"name": "Garage", "ws_path": "/recordings_test_garage", "rec_path": %q}]
+}`, recPath)
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Init(&path); err != nil {
+		t.Fatal(err)
+	}
+	return config.GetCamera("Garage"), recPath
+}
+
+func writeFile(t *testing.T, path, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	cfg, recPath := initConfig(t)
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+
+	// A finished clip with metadata and its mp4.
+	writeFile(t, filepath.Join(recPath, fmt.Sprintf("rec_Garage_%d.ts", base.Unix())), "ts data", base.Add(time.Minute))
+	writeFile(t, filepath.Join(recPath, fmt.Sprintf("rec_Garage_%d.mp4", base.Unix())), "mp4 data!", base.Add(2*time.Minute))
+	writeFile(t, filepath.Join(recPath, fmt.Sprintf("rec_Garage_%d.json", base.Unix())),
+		fmt.Sprintf(`{"camera": "Garage", "start": %d, "end": %d, "classes": ["people"]}`, base.Unix(), base.Add(90*time.Second).Unix()), base)
+
+	// A clip that is still being remuxed.
+	later := base.Add(time.Hour)
+	writeFile(t, filepath.Join(recPath, fmt.Sprintf("rec_Garage_%d.ts", later.Unix())), "ts data", later.Add(time.Minute))
+	writeFile(t, filepath.Join(recPath, fmt.Sprintf("rec_Garage_%d%s", later.Unix(), alarm.REMUX_TEMP_SUFFIX)), "partial", later.Add(time.Minute))
+
+	// A daily archive, and files that are not recordings of this camera.
+	day := time.Date(2025, 2, 28, 0, 0, 0, 0, time.Local)
+	writeFile(t, filepath.Join(recPath, "archive", "Garage_2025-02-28.mp4"), "archive", day.Add(24*time.Hour))
+	writeFile(t, filepath.Join(recPath, "archive", "Garage_2025-02-28.json"), `[{"classes": ["vehicle"]}, {"classes": ["people", "vehicle"]}]`, day)
+	writeFile(t, filepath.Join(recPath, fmt.Sprintf("rec_Yard_%d.ts", base.Unix())), "other", base)
+	writeFile(t, filepath.Join(recPath, "rec_Garage_notatime.ts"), "junk", base)
+
+	idx := recordings.NewIndex()
+	list := idx.List(cfg, time.Time{}, time.Time{})
+	if len(list) != 3 {
+		t.Fatalf("got %d recordings, want 3", len(list))
+	}
+
+	arc, clip, remuxing := list[0], list[1], list[2]
+	if arc.Kind != recordings.KIND_ARCHIVE || arc.ID != "Garage_2025-02-28" || !arc.Start.Equal(day) || !arc.End.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("unexpected archive %+v", arc)
+	}
+	if len(arc.Classes) != 2 || arc.Classes[0] != alarm.CLASS_VEHICLE || arc.Classes[1] != alarm.CLASS_PEOPLE {
+		t.Errorf("archive classes %v", arc.Classes)
+	}
+
+	if clip.Kind != recordings.KIND_CLIP || !clip.Start.Equal(base) || !clip.End.Equal(base.Add(90*time.Second)) {
+		t.Errorf("unexpected clip %+v", clip)
+	}
+	if len(clip.Classes) != 1 || clip.Classes[0] != alarm.CLASS_PEOPLE {
+		t.Errorf("clip classes %v", clip.Classes)
+	}
+	if clip.Files["ts"] != 7 || clip.Files["mp4"] != 9 {
+		t.Errorf("clip files %v", clip.Files)
+	}
+	if path, format := clip.Path(""); format != "mp4" || filepath.Ext(path) != ".mp4" {
+		t.Errorf("clip path %s %s, want the mp4", path, format)
+	}
+	if path, format := clip.Path("ts"); format != "ts" || filepath.Ext(path) != ".ts" {
+		t.Errorf("clip ts path %s %s", path, format)
+	}
+
+	// Until the remux has finished only the .ts is listed and served.
+	if len(remuxing.Files) != 1 || remuxing.Files["ts"] != 7 {
+		t.Errorf("remuxing files %v", remuxing.Files)
+	}
+	if !remuxing.End.Equal(later.Add(time.Minute)) {
+		t.Errorf("remuxing end %v, want the .ts mtime", remuxing.End)
+	}
+	if _, format := remuxing.Path(""); format != "ts" {
+		t.Errorf("remuxing served as %q, want ts", format)
+	}
+	if path, _ := remuxing.Path("mp4"); path != "" {
+		t.Errorf("remuxing mp4 served from %s", path)
+	}
+
+	if list := idx.List(cfg, base.Add(time.Minute), later); len(list) != 2 || list[0] != clip || list[1] != remuxing {
+		t.Errorf("from/to filter gave %d recordings", len(list))
+	}
+	if list := idx.List(cfg, later.Add(time.Hour), time.Time{}); len(list) != 0 {
+		t.Errorf("from after all recordings gave %d recordings", len(list))
+	}
+	if idx.Get(cfg, clip.ID) != clip || idx.Get(cfg, "rec_Garage_1") != nil {
+		t.Error("unexpected Get result")
+	}
+}
@@ -0,0 +1,201 @@
+package streamer
+
+import (
+	"bv-streamer/config"
+	"bv-streamer/log"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AUTH_MAX_FAILURES = 5
+	AUTH_BLOCK_WINDOW = time.Minute
+
+	// Signed URLs for the all-camera event stream use this scope, signed URLs
+	// for a camera use CameraScope, so the two can never be swapped.
+	AUTH_SCOPE_EVENTS = "events"
+)
+
+type authFailures struct {
+	count int
+	first time.Time
+}
+
+var (
+	authMutex    sync.Mutex
+	authFailed   = make(map[string]*authFailures)
+	authLastTidy time.Time
+)
+
+func CameraScope(name string) string {
+	return "camera:" + strings.ToLower(name)
+}
+
+func Sign(scope, secret string, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("sig", signature(scope, secret, exp))
+	return q
+}
+
+func signature(scope, secret, expires string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%s", scope, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Streamer) authorize(w http.ResponseWriter, r *http.Request) bool {
+	tokens, secret := credentials(s.cfg)
+	return authorize(w, r, s.cfg.Name, CameraScope(s.cfg.Name), tokens, secret)
+}
+
+// Authorize applies the stream credentials of the named camera to other
+// per-camera routes such as the recordings API. Unknown cameras fall back
+// to the global credentials.
+func Authorize(w http.ResponseWriter, r *http.Request, camera string) bool {
+	cfg := config.GetCamera(camera)
+	if cfg == nil {
+		cfg = &config.ConfigCamera{Name: camera}
+	}
+	tokens, secret := credentials(cfg)
+	return authorize(w, r, cfg.Name, CameraScope(cfg.Name), tokens, secret)
+}
+
+func credentials(cfg *config.ConfigCamera) ([]string, string) {
+	global := config.GetConfigGlobal()
+	tokens := append(append([]string{}, cfg.AuthTokens...), global.AuthTokens...)
+	secret := cfg.AuthSecret
+	if secret == "" {
+		secret = global.AuthSecret
+	}
+	return tokens, secret
+}
+
+func authorize(w http.ResponseWriter, r *http.Request, name, scope string, tokens []string, secret string) bool {
+	if len(tokens) == 0 && secret == "" {
+		return true
+	}
+
+	addr := remoteHost(r)
+	if blocked(addr) {
+		log.Warnf("[%s] Auth blocked, too many failures [%s]", name, addr)
+		http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+		return false
+	}
+
+	reason := checkCredentials(r, scope, tokens, secret)
+	if reason == "" {
+		return true
+	}
+
+	fail(addr)
+	log.Warnf("[%s] Auth failed: %s [%s]", name, reason, addr)
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func checkCredentials(r *http.Request, scope string, tokens []string, secret string) string {
+	q := r.URL.Query()
+
+	token := q.Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	if token != "" {
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return ""
+			}
+		}
+		return "invalid token"
+	}
+
+	exp, sig := q.Get("expires"), q.Get("sig")
+	if exp == "" || sig == "" {
+		return "missing credentials"
+	}
+	if secret == "" {
+		return "signed urls not enabled"
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "invalid expiry"
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(scope, secret, exp))) {
+		return "invalid signature"
+	}
+	if time.Now().Unix() > unix {
+		return "signed url expired"
+	}
+	return ""
+}
+
+func authQuery(r *http.Request) string {
+	q := r.URL.Query()
+	out := url.Values{}
+	for _, k := range []string{"token", "expires", "sig"} {
+		if v := q.Get(k); v != "" {
+			out.Set(k, v)
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return "?" + out.Encode()
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func blocked(addr string) bool {
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	f := authFailed[addr]
+	if f == nil {
+		return false
+	}
+	if time.Since(f.first) > AUTH_BLOCK_WINDOW {
+		delete(authFailed, addr)
+		return false
+	}
+	return f.count >= AUTH_MAX_FAILURES
+}
+
+func fail(addr string) {
+	authMutex.Lock()
+	defer authMutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(authLastTidy) > AUTH_BLOCK_WINDOW {
+		for a, f := range authFailed {
+			if now.Sub(f.first) > AUTH_BLOCK_WINDOW {
+				delete(authFailed, a)
+			}
+		}
+		authLastTidy = now
+	}
+
+	f := authFailed[addr]
+	if f == nil || now.Sub(f.first) > AUTH_BLOCK_WINDOW {
+		f = &authFailures{first: now}
+		authFailed[addr] = f
+	}
+	f.count++
+}
@@ -0,0 +1,133 @@
+package streamer
+
+import (
+	"bv-streamer/log"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	SLOW_DROP       string = "drop"
+	SLOW_DISCONNECT string = "disconnect"
+)
+
+type wsMessage struct {
+	typ   int
+	data  []byte
+	media bool
+}
+
+type client struct {
+	s    *Streamer
+	conn *websocket.Conn
+	addr string
+
+	queue    chan wsMessage
+	primed   bool
+	dropping bool
+	dropped  int
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(s *Streamer, conn *websocket.Conn) *client {
+	return &client{
+		s:     s,
+		conn:  conn,
+		addr:  conn.RemoteAddr().String(),
+		queue: make(chan wsMessage, s.queueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+func (c *client) enqueue(msg wsMessage, keyframe bool) bool {
+	if c.dropping {
+		if !keyframe {
+			c.dropped++
+			return true
+		}
+		log.Debugf("[%s] Client resumed at keyframe after %d dropped chunks. [%s]", c.s.cfg.Name, c.dropped, c.addr)
+		c.dropping = false
+		c.dropped = 0
+	}
+
+	select {
+	case c.queue <- msg:
+		return true
+	default:
+	}
+
+	if c.s.slowPolicy == SLOW_DISCONNECT {
+		return false
+	}
+
+	log.Warnf("[%s] Client too slow, dropping to next keyframe. [%s]", c.s.cfg.Name, c.addr)
+	c.dropMedia()
+	if keyframe {
+		select {
+		case c.queue <- msg:
+			log.Debugf("[%s] Client resumed at keyframe after %d dropped chunks. [%s]", c.s.cfg.Name, c.dropped, c.addr)
+			c.dropped = 0
+			return true
+		default:
+		}
+	}
+	c.dropped++
+	c.dropping = true
+	return true
+}
+
+// dropMedia empties the queue of media chunks but keeps the prime, init
+// segment and info messages a resumed client still needs to decode.
+// Only publish fills the queue, so requeueing the kept messages never blocks.
+func (c *client) dropMedia() {
+	var kept []wsMessage
+	for {
+		select {
+		case m := <-c.queue:
+			if m.media {
+				c.dropped++
+			} else {
+				kept = append(kept, m)
+			}
+			continue
+		default:
+		}
+		break
+	}
+	for _, m := range kept {
+		c.queue <- m
+	}
+}
+
+func (c *client) writer() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.queue:
+			c.conn.SetWriteDeadline(time.Now().Add(c.s.writeTimeout))
+			if err := c.conn.WriteMessage(msg.typ, msg.data); err != nil {
+				var nerr net.Error
+				if errors.As(err, &nerr) && nerr.Timeout() {
+					c.s.evict(c, "write timeout")
+				} else {
+					c.s.evict(c, "write error: "+err.Error())
+				}
+				return
+			}
+		}
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
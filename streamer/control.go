@@ -0,0 +1,124 @@
+package streamer
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/log"
+	"fmt"
+	"strings"
+)
+
+func Find(name string) *Streamer {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, s := range Streamers {
+		if strings.EqualFold(s.cfg.Name, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+func StartCamera(name string) error {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+
+	c := config.GetCamera(name)
+	if c == nil {
+		return fmt.Errorf("unknown camera %q", name)
+	}
+	delete(stopped, strings.ToLower(c.Name))
+	if Find(c.Name) != nil {
+		return nil
+	}
+
+	log.Infof("[%s] Starting on request.", c.Name)
+	s := NewStreamer(c)
+	if s == nil {
+		return fmt.Errorf("failed to start %q", c.Name)
+	}
+	go s.Start()
+	return nil
+}
+
+func StopCamera(name string) error {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+
+	c := config.GetCamera(name)
+	if c == nil {
+		return fmt.Errorf("unknown camera %q", name)
+	}
+	stopped[strings.ToLower(c.Name)] = true
+	if s := Find(c.Name); s != nil {
+		log.Infof("[%s] Stopping on request.", c.Name)
+		s.Close()
+	}
+	return nil
+}
+
+func Status(name string) (StreamerStatus, bool) {
+	c := config.GetCamera(name)
+	if c == nil {
+		return StreamerStatus{}, false
+	}
+	if s := Find(c.Name); s != nil {
+		return s.Status(), true
+	}
+	return StreamerStatus{
+		Name:     c.Name,
+		WSPath:   c.WSPath,
+		Format:   format(c),
+		HLS:      c.HLS,
+		Clients:  []ClientStatus{},
+		Tracking: c.Tracking,
+	}, true
+}
+
+func (s *Streamer) Status() StreamerStatus {
+	ing := s.ingest.Status()
+	st := StreamerStatus{
+		Name:     s.cfg.Name,
+		WSPath:   s.cfg.WSPath,
+		Running:  true,
+		Format:   format(s.cfg),
+		HLS:      s.hls != nil,
+		Ingest:   &ing,
+		Clients:  []ClientStatus{},
+		Tracking: s.alarm != nil,
+	}
+
+	s.mutex.Lock()
+	for _, c := range s.clients {
+		st.Clients = append(st.Clients, ClientStatus{Addr: c.addr, Queued: len(c.queue), Dropping: c.dropping})
+	}
+	s.mutex.Unlock()
+
+	if s.alarm != nil {
+		a := s.alarm.Status()
+		st.Alarm = &a
+	}
+	return st
+}
+
+func SetArmed(name string, armed bool) error {
+	s := Find(name)
+	if s == nil || s.Alarm() == nil {
+		return fmt.Errorf("tracking of %q not running", name)
+	}
+	if armed {
+		return s.Alarm().Arm()
+	}
+	return s.Alarm().Disarm()
+}
+
+func (s *Streamer) Alarm() *alarm.Alarm {
+	return s.alarm
+}
+
+func format(c *config.ConfigCamera) string {
+	if strings.EqualFold(c.WSFormat, FORMAT_FMP4) {
+		return FORMAT_FMP4
+	}
+	return FORMAT_MPEGTS
+}
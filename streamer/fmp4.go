@@ -0,0 +1,122 @@
+package streamer
+
+import (
+	"bv-streamer/fmp4"
+	"bv-streamer/log"
+	"bv-streamer/ts"
+	"bytes"
+	"encoding/json"
+)
+
+const (
+	FORMAT_MPEGTS string = "mpegts"
+	FORMAT_FMP4   string = "fmp4"
+
+	fmp4DefaultDuration uint32 = 3000
+	fmp4MaxDuration     int64  = 10 * fmp4.TIMESCALE
+)
+
+type fmp4Muxer struct {
+	init     []byte
+	info     []byte
+	sps      []byte
+	pps      []byte
+	seq      uint32
+	firstDTS int64
+	lastDTS  int64
+	duration uint32
+	warned   bool
+}
+
+func (m *fmp4Muxer) reset() {
+	m.firstDTS = -1
+	m.lastDTS = -1
+	m.duration = fmp4DefaultDuration
+}
+
+func (s *Streamer) onFrame(f *ts.Frame) {
+	m := s.mux
+	if f.StreamType != ts.STREAM_TYPE_H264 {
+		if !m.warned {
+			log.Errorf("[%s] fmp4 output supports H.264 only, stream type 0x%02x.", s.cfg.Name, f.StreamType)
+			m.warned = true
+		}
+		return
+	}
+
+	var nals [][]byte
+	var sps, pps []byte
+	for _, nal := range ts.SplitNALs(f.Data) {
+		switch ts.NALType(f.StreamType, nal) {
+		case ts.NAL_H264_SPS:
+			sps = nal
+		case ts.NAL_H264_PPS:
+			pps = nal
+		case ts.NAL_H264_AUD:
+		default:
+			nals = append(nals, nal)
+		}
+	}
+
+	if sps != nil && pps != nil && (!bytes.Equal(sps, m.sps) || !bytes.Equal(pps, m.pps)) {
+		info, err := fmp4.ParseSPS(sps)
+		if err != nil {
+			log.Errorf("[%s] %v", s.cfg.Name, err)
+			return
+		}
+		changed := m.init != nil
+		m.sps = append([]byte(nil), sps...)
+		m.pps = append([]byte(nil), pps...)
+		m.init = fmp4.InitSegment(m.sps, m.pps, info)
+		m.info, _ = json.Marshal(map[string]any{
+			"mime":   `video/mp4; codecs="` + info.Codec() + `"`,
+			"codec":  info.Codec(),
+			"width":  info.Width,
+			"height": info.Height,
+		})
+		log.Infof("[%s] fmp4 init %dx%d %s.", s.cfg.Name, info.Width, info.Height, info.Codec())
+		if changed {
+			s.evictAll("stream parameters changed")
+		}
+		m.reset()
+	}
+	if m.init == nil || len(nals) == 0 || f.DTS < 0 {
+		return
+	}
+
+	if m.firstDTS < 0 {
+		if !f.Keyframe {
+			return
+		}
+		m.firstDTS = f.DTS
+	}
+	if m.lastDTS >= 0 {
+		if d := wrap(f.DTS - m.lastDTS); d > 0 && d < fmp4MaxDuration {
+			m.duration = uint32(d)
+		}
+	}
+	m.lastDTS = f.DTS
+
+	m.seq++
+	frag := fmp4.Fragment(m.seq, uint64(wrap(f.DTS-m.firstDTS)), []fmp4.Sample{{
+		Data:      fmp4.AVCC(nals),
+		Duration:  m.duration,
+		CTSOffset: int32(wrap(f.PTS - f.DTS)),
+		Keyframe:  f.Keyframe,
+	}})
+	s.publish(frag, f.Keyframe, m.prime, m.info)
+}
+
+func (m *fmp4Muxer) prime(gop []byte) []byte {
+	if m.init == nil {
+		return nil
+	}
+	return append(append([]byte(nil), m.init...), gop...)
+}
+
+func wrap(d int64) int64 {
+	if d < 0 {
+		d += 1 << 33
+	}
+	return d
+}
@@ -0,0 +1,484 @@
+package streamer
+
+import (
+	"bv-streamer/config"
+	"bv-streamer/log"
+	"bv-streamer/ts"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hlsIdleTimeout  time.Duration = 30 * time.Second
+	hlsWaitFirst    time.Duration = 10 * time.Second
+	hlsMaxSegment   int           = 16 << 20
+	hlsClockRate    float64       = 90000
+	hlsPlaylistName string        = "index.m3u8"
+)
+
+type hlsPart struct {
+	index       int
+	duration    float64
+	independent bool
+	start, end  int
+}
+
+type hlsSegment struct {
+	seq           int
+	duration      float64
+	data          []byte
+	parts         []*hlsPart
+	complete      bool
+	discontinuity bool
+}
+
+type HLS struct {
+	name       string
+	dir        string
+	lowLatency bool
+	target     float64
+	partTarget float64
+	window     int
+
+	mutex     sync.Mutex
+	demuxer   *ts.Demuxer
+	segments  []*hlsSegment
+	current   *hlsSegment
+	seq       int
+	discSeq   int
+	broken    bool
+	segPTS    int64
+	partPTS   int64
+	partStart int
+	lastPTS   int64
+	notify    chan struct{}
+	active    bool
+	lastReq   time.Time
+}
+
+func NewHLS(c *config.ConfigCamera) *HLS {
+	h := &HLS{
+		name:       c.Name,
+		dir:        c.HLSDir,
+		lowLatency: c.HLSLowLatency,
+		target:     2,
+		partTarget: 0.5,
+		window:     6,
+		demuxer:    ts.NewDemuxer(),
+		notify:     make(chan struct{}),
+	}
+	if c.HLSSegment > 0 {
+		h.target = c.HLSSegment
+	}
+	if c.HLSPart > 0 {
+		h.partTarget = c.HLSPart
+	}
+	if c.HLSWindow > 0 {
+		h.window = c.HLSWindow
+	}
+	if h.dir != "" {
+		h.dir = filepath.Join(h.dir, c.Name)
+		if err := os.MkdirAll(h.dir, 0755); err != nil {
+			log.Errorf("[%s] Could not create hls dir, keeping segments in memory: %v", c.Name, err)
+			h.dir = ""
+		}
+	}
+	return h
+}
+
+func (h *HLS) Write(b []byte) (int, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.demuxer.Write(b, h.chunk)
+	return len(b), nil
+}
+
+// Reset is called when ffmpeg restarts. The open segment is closed with
+// what it has and the next one is marked as a discontinuity, as timestamps
+// and continuity counters start over.
+func (h *HLS) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.demuxer.Reset()
+	h.closeSegment(-1)
+	h.broken = true
+	h.wake()
+}
+
+func (h *HLS) chunk(c *ts.Chunk) {
+	pts := c.PTS
+	if pts >= 0 {
+		h.lastPTS = pts
+	}
+
+	if c.Keyframe && (h.current == nil || h.elapsed(h.segPTS, pts) >= h.target) {
+		h.closeSegment(pts)
+		h.openSegment(pts)
+	} else if h.current != nil && c.FrameStart && h.lowLatency && h.elapsed(h.partPTS, pts) >= h.partTarget {
+		h.closePart(pts, false)
+	}
+
+	if h.current == nil {
+		return
+	}
+	if len(h.current.data)+len(c.Data) > hlsMaxSegment {
+		log.Warnf("[%s] HLS segment exceeds %d bytes, dropping until next keyframe.", h.name, hlsMaxSegment)
+		h.closeSegment(pts)
+		h.broken = true
+		return
+	}
+	h.current.data = append(h.current.data, c.Data...)
+}
+
+func (h *HLS) elapsed(from, to int64) float64 {
+	if from < 0 || to < 0 {
+		return 0
+	}
+	d := to - from
+	if d < 0 {
+		d += 1 << 33
+	}
+	return float64(d) / hlsClockRate
+}
+
+func (h *HLS) openSegment(pts int64) {
+	h.current = &hlsSegment{seq: h.seq, discontinuity: h.broken && len(h.segments) > 0}
+	h.seq++
+	h.broken = false
+	h.segPTS = pts
+	h.partPTS = pts
+	h.partStart = 0
+	if header := h.demuxer.Header(); header != nil {
+		h.current.data = append(h.current.data, header...)
+	}
+}
+
+func (h *HLS) closePart(pts int64, last bool) {
+	seg := h.current
+	if len(seg.data) == h.partStart {
+		return
+	}
+	part := &hlsPart{
+		index:       len(seg.parts),
+		duration:    h.elapsed(h.partPTS, pts),
+		independent: len(seg.parts) == 0,
+		start:       h.partStart,
+		end:         len(seg.data),
+	}
+	seg.parts = append(seg.parts, part)
+	h.partStart = part.end
+	h.partPTS = pts
+
+	if h.dir != "" {
+		h.store(fmt.Sprintf("part_%d_%d.ts", seg.seq, part.index), seg.data[part.start:part.end])
+	}
+	if !last {
+		h.wake()
+	}
+}
+
+func (h *HLS) closeSegment(pts int64) {
+	seg := h.current
+	if seg == nil {
+		return
+	}
+	if pts < 0 {
+		pts = h.lastPTS
+	}
+	if h.lowLatency {
+		h.closePart(pts, true)
+	}
+	seg.duration = h.elapsed(h.segPTS, pts)
+	seg.complete = true
+	h.current = nil
+
+	if h.dir != "" {
+		h.store(fmt.Sprintf("seg_%d.ts", seg.seq), seg.data)
+		seg.data = nil
+	}
+
+	h.segments = append(h.segments, seg)
+	for len(h.segments) > h.window+2 {
+		old := h.segments[0]
+		h.segments = h.segments[1:]
+		if old.discontinuity {
+			h.discSeq++
+		}
+		if h.dir != "" {
+			os.Remove(filepath.Join(h.dir, fmt.Sprintf("seg_%d.ts", old.seq)))
+			for _, p := range old.parts {
+				os.Remove(filepath.Join(h.dir, fmt.Sprintf("part_%d_%d.ts", old.seq, p.index)))
+			}
+		}
+	}
+	h.wake()
+}
+
+func (h *HLS) store(name string, data []byte) {
+	if err := os.WriteFile(filepath.Join(h.dir, name), data, 0644); err != nil {
+		log.Errorf("[%s] HLS write error: %v", h.name, err)
+	}
+}
+
+func (h *HLS) wake() {
+	close(h.notify)
+	h.notify = make(chan struct{})
+}
+
+func (h *HLS) clear() {
+	h.demuxer.Reset()
+	h.current = nil
+	if h.dir != "" {
+		if entries, err := os.ReadDir(h.dir); err == nil {
+			for _, e := range entries {
+				os.Remove(filepath.Join(h.dir, e.Name()))
+			}
+		}
+	}
+	h.segments = nil
+	h.broken = false
+	h.wake()
+}
+
+func (h *HLS) touch(s *Streamer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastReq = time.Now()
+	if !h.active {
+		h.active = true
+		log.Infof("[%s] HLS output start.", h.name)
+		s.ingest.Subscribe(h)
+		go h.idleRunner(s)
+	}
+}
+
+func (h *HLS) idleRunner(s *Streamer) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(hlsIdleTimeout / 3):
+			h.mutex.Lock()
+			if time.Since(h.lastReq) > hlsIdleTimeout {
+				s.ingest.Unsubscribe(h)
+				h.active = false
+				h.clear()
+				h.mutex.Unlock()
+				log.Infof("[%s] HLS output idle, stopped.", h.name)
+				return
+			}
+			h.mutex.Unlock()
+		}
+	}
+}
+
+func (h *HLS) wait(ready func() bool, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		h.mutex.Lock()
+		ok := ready()
+		notify := h.notify
+		h.mutex.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-notify:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func (h *HLS) find(seq int) *hlsSegment {
+	if h.current != nil && h.current.seq == seq {
+		return h.current
+	}
+	for _, seg := range h.segments {
+		if seg.seq == seq {
+			return seg
+		}
+	}
+	return nil
+}
+
+func (h *HLS) serve(s *Streamer, w http.ResponseWriter, r *http.Request) {
+	h.touch(s)
+	h.ServeHTTP(w, r)
+}
+
+// ServeHTTP serves the playlist, segments and parts by the last path element.
+func (h *HLS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	switch {
+	case name == hlsPlaylistName:
+		h.servePlaylist(w, r)
+	case strings.HasPrefix(name, "seg_") && strings.HasSuffix(name, ".ts"):
+		seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "seg_"), ".ts"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.serveSegment(w, r, name, seq, -1)
+	case strings.HasPrefix(name, "part_") && strings.HasSuffix(name, ".ts"):
+		var seq, idx int
+		if _, err := fmt.Sscanf(name, "part_%d_%d.ts", &seq, &idx); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.serveSegment(w, r, name, seq, idx)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *HLS) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	if !h.wait(func() bool { return len(h.segments) > 0 }, hlsWaitFirst) {
+		http.Error(w, "Stream not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	if msn := r.URL.Query().Get("_HLS_msn"); msn != "" && h.lowLatency {
+		seq, err := strconv.Atoi(msn)
+		if err != nil {
+			http.Error(w, "Invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		part := -1
+		if p := r.URL.Query().Get("_HLS_part"); p != "" {
+			if part, err = strconv.Atoi(p); err != nil {
+				http.Error(w, "Invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+		h.wait(func() bool {
+			seg := h.find(seq)
+			if seg == nil {
+				return len(h.segments) > 0 && h.segments[len(h.segments)-1].seq >= seq
+			}
+			return seg.complete || (part >= 0 && len(seg.parts) > part)
+		}, time.Duration(3*h.target*float64(time.Second)))
+	}
+
+	h.mutex.Lock()
+	playlist := h.playlist(authQuery(r))
+	h.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(playlist))
+}
+
+func (h *HLS) playlist(query string) string {
+	segments := h.segments
+	discSeq := h.discSeq
+	if len(segments) > h.window {
+		for _, seg := range segments[:len(segments)-h.window] {
+			if seg.discontinuity {
+				discSeq++
+			}
+		}
+		segments = segments[len(segments)-h.window:]
+	}
+
+	maxDur := h.target
+	for _, seg := range segments {
+		maxDur = math.Max(maxDur, seg.duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if h.lowLatency {
+		b.WriteString("#EXT-X-VERSION:9\n")
+	} else {
+		b.WriteString("#EXT-X-VERSION:3\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(maxDur)))
+	if h.lowLatency {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*h.partTarget)
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", h.partTarget)
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].seq)
+	if discSeq > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discSeq)
+	}
+
+	for i, seg := range segments {
+		if seg.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if h.lowLatency && i >= len(segments)-3 {
+			writeParts(&b, seg, query)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\nseg_%d.ts%s\n", seg.duration, seg.seq, query)
+	}
+
+	if h.lowLatency && h.current != nil {
+		if h.current.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		writeParts(&b, h.current, query)
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part_%d_%d.ts%s\"\n", h.current.seq, len(h.current.parts), query)
+	}
+	return b.String()
+}
+
+func writeParts(b *strings.Builder, seg *hlsSegment, query string) {
+	for _, p := range seg.parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"part_%d_%d.ts%s\"", p.duration, seg.seq, p.index, query)
+		if p.independent {
+			b.WriteString(",INDEPENDENT=YES")
+		}
+		b.WriteString("\n")
+	}
+}
+
+func (h *HLS) serveSegment(w http.ResponseWriter, r *http.Request, name string, seq, idx int) {
+	var data []byte
+	ready := h.wait(func() bool {
+		seg := h.find(seq)
+		if seg == nil {
+			return false
+		}
+		if idx < 0 {
+			return seg.complete
+		}
+		return len(seg.parts) > idx
+	}, time.Duration(3*h.target*float64(time.Second)))
+	if !ready {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.mutex.Lock()
+	if seg := h.find(seq); seg != nil && seg.data != nil {
+		if idx < 0 {
+			data = seg.data
+		} else if idx < len(seg.parts) {
+			data = seg.data[seg.parts[idx].start:seg.parts[idx].end]
+		}
+	}
+	h.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	if data == nil && h.dir != "" {
+		http.ServeFile(w, r, filepath.Join(h.dir, name))
+		return
+	}
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,56 @@
+package streamer
+
+import (
+	"bv-streamer/metrics"
+)
+
+var (
+	metricConnects  = metrics.NewCounter("bv_clients_connected_total", "Livestream clients accepted.", "camera")
+	metricEvictions = metrics.NewCounter("bv_client_evictions_total", "Livestream clients evicted by reason.", "camera", "reason")
+)
+
+func init() {
+	metrics.NewGaugeFunc("bv_clients", "Connected livestream clients.", func() []metrics.Sample {
+		return collect(func(st StreamerStatus) (float64, bool) { return float64(len(st.Clients)), true })
+	}, "camera")
+	metrics.NewGaugeFunc("bv_ffmpeg_up", "Whether the ffmpeg ingest process is running.", func() []metrics.Sample {
+		return collect(func(st StreamerStatus) (float64, bool) { return bool2float(st.Ingest.Running), true })
+	}, "camera")
+	metrics.NewGaugeFunc("bv_alarm_active", "Whether the camera is in ALARM state.", func() []metrics.Sample {
+		return collect(func(st StreamerStatus) (float64, bool) {
+			if st.Alarm == nil {
+				return 0, false
+			}
+			return bool2float(st.Alarm.State == "ALARM"), true
+		})
+	}, "camera")
+	metrics.NewGaugeFunc("bv_alarm_armed", "Whether tracking is armed.", func() []metrics.Sample {
+		return collect(func(st StreamerStatus) (float64, bool) {
+			if st.Alarm == nil {
+				return 0, false
+			}
+			return bool2float(st.Alarm.Armed), true
+		})
+	}, "camera")
+}
+
+func collect(value func(StreamerStatus) (float64, bool)) []metrics.Sample {
+	mutex.Lock()
+	list := append([]*Streamer{}, Streamers...)
+	mutex.Unlock()
+
+	var samples []metrics.Sample
+	for _, s := range list {
+		if v, ok := value(s.Status()); ok {
+			samples = append(samples, metrics.Sample{Labels: []string{s.cfg.Name}, Value: v})
+		}
+	}
+	return samples
+}
+
+func bool2float(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
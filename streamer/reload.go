@@ -0,0 +1,89 @@
+package streamer
+
+import (
+	"bv-streamer/config"
+	"bv-streamer/log"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	controlMutex sync.Mutex
+	stopped      = make(map[string]bool)
+)
+
+func Reload(path *string) error {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+
+	select {
+	case <-config.SigShutdown:
+		return fmt.Errorf("shutting down")
+	default:
+	}
+
+	old, issues, err := config.Reload(path)
+	for _, issue := range issues {
+		log.Warnf("Config-%v", issue)
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg := config.GetConfigGlobal()
+	if old.WShost != cfg.WShost || old.WSPort != cfg.WSPort || old.RecAPI != cfg.RecAPI || old.ReloadAPI != cfg.ReloadAPI || old.MetricsAPI != cfg.MetricsAPI || old.EventsAPI != cfg.EventsAPI ||
+		old.TLSCert != cfg.TLSCert || old.TLSKey != cfg.TLSKey || old.HTTPPort != cfg.HTTPPort || old.HTTPRedirect != cfg.HTTPRedirect ||
+		old.AdminHost != cfg.AdminHost || old.AdminPort != cfg.AdminPort {
+		log.Warnf("Global listener settings changed, restart required to apply them.")
+	}
+	if !reflect.DeepEqual(old.MQTT, cfg.MQTT) {
+		log.Warnf("MQTT settings changed, restart required to apply them.")
+	}
+	if !reflect.DeepEqual(old.Webhooks, cfg.Webhooks) || old.WebhookQueue != cfg.WebhookQueue || old.WebhookMaxAge != cfg.WebhookMaxAge ||
+		old.WebhookRetry != cfg.WebhookRetry || old.PublicURL != cfg.PublicURL {
+		log.Warnf("Webhook settings changed, restart required to apply them.")
+	}
+
+	mutex.Lock()
+	running := append([]*Streamer{}, Streamers...)
+	mutex.Unlock()
+
+	seen := make(map[string]bool)
+	var started []*config.ConfigCamera
+	for _, s := range running {
+		name := strings.ToLower(s.cfg.Name)
+		seen[name] = true
+
+		c := config.GetCamera(name)
+		switch {
+		case c == nil:
+			log.Infof("[%s] Removed from config, stopping.", s.cfg.Name)
+			s.Close()
+		case !reflect.DeepEqual(*s.cfg, *c):
+			log.Infof("[%s] Config changed, restarting.", s.cfg.Name)
+			s.Close()
+			started = append(started, c)
+		default:
+			log.Debugf("[%s] Config unchanged.", s.cfg.Name)
+		}
+	}
+	for _, c := range config.GetCameras() {
+		if name := strings.ToLower(c.Name); !seen[name] && !stopped[name] {
+			log.Infof("[%s] Added to config, starting.", c.Name)
+			started = append(started, c)
+		}
+	}
+
+	for _, c := range started {
+		if s := NewStreamer(c); s != nil {
+			go s.Start()
+		} else {
+			log.Errorf("[%s] Failed to start streaming.", c.Name)
+		}
+	}
+
+	log.Infof("Config reloaded.")
+	return nil
+}
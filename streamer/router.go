@@ -0,0 +1,91 @@
+package streamer
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var Routes = NewRouter()
+
+type Router struct {
+	mutex    sync.RWMutex
+	routes   map[string]http.HandlerFunc
+	gone     map[string]bool
+	fallback http.Handler
+}
+
+func NewRouter() *Router {
+	return &Router{
+		routes: make(map[string]http.HandlerFunc),
+		gone:   make(map[string]bool),
+	}
+}
+
+func (rt *Router) SetFallback(h http.Handler) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.fallback = h
+}
+
+func (rt *Router) Add(path string, h http.HandlerFunc) bool {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if _, found := rt.routes[path]; found {
+		return false
+	}
+	rt.routes[path] = h
+	delete(rt.gone, path)
+	return true
+}
+
+func (rt *Router) Remove(path string) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if _, found := rt.routes[path]; found {
+		delete(rt.routes, path)
+		rt.gone[path] = true
+	}
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, gone, fallback := rt.match(r.URL.Path)
+	switch {
+	case h != nil:
+		h(w, r)
+	case gone:
+		http.Error(w, "Camera removed", http.StatusGone)
+	case fallback != nil:
+		fallback.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (rt *Router) match(path string) (http.HandlerFunc, bool, http.Handler) {
+	rt.mutex.RLock()
+	defer rt.mutex.RUnlock()
+
+	if h, found := rt.routes[path]; found {
+		return h, false, nil
+	}
+	if rt.gone[path] {
+		return nil, true, nil
+	}
+
+	best := ""
+	for p := range rt.routes {
+		if strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) && len(p) > len(best) {
+			best = p
+		}
+	}
+	if best != "" {
+		return rt.routes[best], false, nil
+	}
+	for p := range rt.gone {
+		if strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return nil, true, nil
+		}
+	}
+	return nil, false, rt.fallback
+}
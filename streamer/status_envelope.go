@@ -0,0 +1,24 @@
+package streamer
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/ingest"
+)
+
+type ClientStatus struct {
+	Addr     string `json:"addr"`
+	Queued   int    `json:"queued"`
+	Dropping bool   `json:"dropping"`
+}
+
+type StreamerStatus struct {
+	Name     string               `json:"name"`
+	WSPath   string               `json:"ws_path"`
+	Running  bool                 `json:"running"`
+	Format   string               `json:"ws_format"`
+	HLS      bool                 `json:"hls"`
+	Ingest   *ingest.IngestStatus `json:"ingest,omitempty"`
+	Clients  []ClientStatus       `json:"clients"`
+	Tracking bool                 `json:"tracking"`
+	Alarm    *alarm.AlarmStatus   `json:"alarm,omitempty"`
+}
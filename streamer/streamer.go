@@ -3,10 +3,12 @@ package streamer
 import (
 	"bv-streamer/alarm"
 	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/ingest"
+	"bv-streamer/lifecycle"
 	"bv-streamer/log"
-	"io"
+	"bv-streamer/ts"
 	"net/http"
-	"os/exec"
 	"strings"
 	"sync"
 	"time"
@@ -15,102 +17,204 @@ import (
 )
 
 const (
-	maxRestarts  int           = 5
-	restartDelay time.Duration = 3 * time.Second
+	gopCacheMax int = 8 << 20
 )
 
 var (
 	mutex     sync.Mutex
-	handlers  = make(map[string]http.HandlerFunc)
 	Streamers = make([]*Streamer, 0)
 )
 
 type Streamer struct {
-	cfg   *config.ConfigCamera
-	alarm *alarm.Alarm
+	cfg    *config.ConfigCamera
+	alarm  *alarm.Alarm
+	ingest *ingest.Ingest
+	hls    *HLS
 
 	upgrader websocket.Upgrader
 
-	mutex        sync.Mutex
-	ffmpegCmd    *exec.Cmd
-	restartCount int
-	clients      map[*websocket.Conn]bool
-	done         chan struct{}
+	mutex     sync.Mutex
+	clients   map[*websocket.Conn]*client
+	gop       []byte
+	gopValid  bool
+	done      chan struct{}
+	closeOnce sync.Once
+	stopped   func()
+
+	pipeMutex sync.Mutex
+	demuxer   *ts.Demuxer
+	mux       *fmp4Muxer
+
+	queueSize    int
+	slowPolicy   string
+	writeTimeout time.Duration
 }
 
 func (s *Streamer) registerHandler() bool {
-	if _, found := handlers[s.cfg.WSPath]; !found {
-		mutex.Lock()
-		handlers[s.cfg.WSPath] = s.handler
-		mutex.Unlock()
-		http.HandleFunc(s.cfg.WSPath, s.handler)
-		return true
-	}
-	return false
+	if !Routes.Add(s.cfg.WSPath, s.handler) {
+		return false
+	}
+	if !Routes.Add(s.cfg.WSPath+"/events", s.eventsHandler) {
+		Routes.Remove(s.cfg.WSPath)
+		return false
+	}
+	if s.hls != nil && !Routes.Add(s.cfg.WSPath+"/", s.hlsHandler) {
+		Routes.Remove(s.cfg.WSPath)
+		Routes.Remove(s.cfg.WSPath + "/events")
+		return false
+	}
+	return true
 }
 
 func (s *Streamer) unregisterHandler() {
-	if _, found := handlers[s.cfg.WSPath]; found {
-		mutex.Lock()
-		delete(handlers, s.cfg.WSPath)
-		mutex.Unlock()
+	Routes.Remove(s.cfg.WSPath)
+	Routes.Remove(s.cfg.WSPath + "/events")
+	if s.hls != nil {
+		Routes.Remove(s.cfg.WSPath + "/")
 	}
 }
 
 func NewStreamer(c *config.ConfigCamera) *Streamer {
-	s := &Streamer{cfg: c}
+	s := &Streamer{
+		cfg:          c,
+		ingest:       ingest.NewIngest(c),
+		demuxer:      ts.NewDemuxer(),
+		clients:      make(map[*websocket.Conn]*client),
+		queueSize:    64,
+		slowPolicy:   SLOW_DROP,
+		writeTimeout: 5 * time.Second,
+	}
+
+	if c.ClientQueue > 0 {
+		s.queueSize = c.ClientQueue
+	}
+	if c.WriteTimeout > 0 {
+		s.writeTimeout = time.Duration(c.WriteTimeout) * time.Second
+	}
+	switch strings.ToLower(c.WSFormat) {
+	case "", FORMAT_MPEGTS:
+	case FORMAT_FMP4:
+		s.mux = &fmp4Muxer{}
+		s.mux.reset()
+		s.demuxer.OnFrame = s.onFrame
+	default:
+		log.Warnf("[%s] Unknown ws_format %q, using %s.", c.Name, c.WSFormat, FORMAT_MPEGTS)
+	}
+	switch strings.ToLower(c.SlowClient) {
+	case "", SLOW_DROP:
+	case SLOW_DISCONNECT:
+		s.slowPolicy = SLOW_DISCONNECT
+	default:
+		log.Warnf("[%s] Unknown slow_client policy %q, using %s.", c.Name, c.SlowClient, SLOW_DROP)
+	}
+
+	if c.HLS {
+		s.hls = NewHLS(c)
+	}
 
 	if !s.registerHandler() {
+		s.ingest.Close()
 		return nil
 	}
+	s.stopped = lifecycle.Track(s.cfg.Name + "/streamer")
 	s.shutdownHandler()
 	s.upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			header := strings.ToLower(r.Header.Get("Origin"))
-			for i := range s.cfg.Origins {
-				if s.cfg.Origins[i] == header {
-					return true
-				}
-			}
-			return false
-		},
+		CheckOrigin: s.checkOrigin,
 	}
 
 	if s.cfg.Tracking {
-		s.alarm = alarm.NewAlarm(s.cfg)
-		go s.alarm.Run()
+		if s.alarm = alarm.NewAlarm(s.cfg, s.ingest); s.alarm != nil {
+			lifecycle.Go(s.cfg.Name+"/alarm", s.alarm.Run)
+		} else {
+			log.Errorf("[%s] Tracking disabled, no usable event source.", s.cfg.Name)
+		}
 	}
 
+	mutex.Lock()
 	Streamers = append(Streamers, s)
+	mutex.Unlock()
 	return s
 
 }
 
 func (s *Streamer) Start() {
 	log.Infof("[%s] Starting streamer...\n", s.cfg.Name)
-
-	s.clients = make(map[*websocket.Conn]bool)
-	go s.ffmpegRunner()
 	<-s.done
 }
 
 func (s *Streamer) Close() {
+	s.closeOnce.Do(s.close)
+}
+
+func (s *Streamer) close() {
 	log.Infof("[%s] Closing streamer...\n", s.cfg.Name)
 	s.unregisterHandler()
 
+	mutex.Lock()
+	for i := range Streamers {
+		if Streamers[i] == s {
+			Streamers = append(Streamers[:i], Streamers[i+1:]...)
+			break
+		}
+	}
+	mutex.Unlock()
+
+	if s.alarm != nil {
+		s.alarm.Stop()
+	}
+
 	s.mutex.Lock()
-	for c := range s.clients {
-		c.Close()
-		delete(s.clients, c)
+	for conn, c := range s.clients {
+		c.close()
+		delete(s.clients, conn)
 	}
 	s.clients = nil
 	s.mutex.Unlock()
 
-	s.destroyFFmpeg()
+	s.ingest.Unsubscribe(s)
+	s.ingest.Close()
 	close(s.done)
+	s.stopped()
+}
+
+func (s *Streamer) checkOrigin(r *http.Request) bool {
+	return config.OriginAllowed(r.Header.Get("Origin"), s.cfg.AllowedOrigins())
+}
+
+func (s *Streamer) hlsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Origin") != "" {
+		if !s.checkOrigin(r) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		w.Header().Set("Vary", "Origin")
+	}
+	if !s.authorize(w, r) {
+		return
+	}
+	s.hls.serve(s, w, r)
+}
+
+func (s *Streamer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Origin") != "" {
+		if !s.checkOrigin(r) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		w.Header().Set("Vary", "Origin")
+	}
+	if !s.authorize(w, r) {
+		return
+	}
+	events.Serve(w, r, s.checkOrigin, s.cfg.Name)
 }
 
 func (s *Streamer) handler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		http.Error(w, "WebSocket upgrade failed", http.StatusBadRequest)
@@ -121,31 +225,37 @@ func (s *Streamer) handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	c := newClient(s, conn)
 	s.mutex.Lock()
-	s.clients[conn] = true
+	if s.clients == nil {
+		s.mutex.Unlock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "streamer closed"), time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+	s.clients[conn] = c
+	s.ingest.Subscribe(s)
 	s.mutex.Unlock()
+	metricConnects.Inc(s.cfg.Name)
 
-	go s.clientRunner(conn)
+	go c.writer()
+	go s.clientRunner(c)
 }
 
-func (s *Streamer) clientRunner(conn *websocket.Conn) {
-	defer func() {
-		conn.Close()
-		s.mutex.Lock()
-		delete(s.clients, conn)
-		s.mutex.Unlock()
-	}()
+func (s *Streamer) clientRunner(c *client) {
+	defer s.removeClient(c)
 
-	addr := conn.RemoteAddr().String()
-	log.Infof("[%s] Client-runner start. [%s]", s.cfg.Name, addr)
+	log.Infof("[%s] Client-runner start. [%s]", s.cfg.Name, c.addr)
 	for {
-		if _, _, err := conn.NextReader(); err != nil {
-			log.Errorf("[%s] Client-runner stop by error - %v - [%s]", s.cfg.Name, err, addr)
+		if _, _, err := c.conn.NextReader(); err != nil {
+			log.Errorf("[%s] Client-runner stop by error - %v - [%s]", s.cfg.Name, err, c.addr)
 			return
 		}
 		select {
 		case <-s.done:
-			log.Infof("[%s] Client-runner stop. [%s]", s.cfg.Name, addr)
+			log.Infof("[%s] Client-runner stop. [%s]", s.cfg.Name, c.addr)
+			return
+		case <-c.done:
 			return
 		default:
 			continue
@@ -153,188 +263,119 @@ func (s *Streamer) clientRunner(conn *websocket.Conn) {
 	}
 }
 
-func (s *Streamer) ffmpegRunner() {
-
-	log.Infof("[%s] FFmpeg-runner start.", s.cfg.Name)
-	ffmpegDone := make(chan error, 1)
-	var ffmpegRunning bool
-
-	for {
+func (s *Streamer) removeClient(c *client) {
+	c.close()
+	// Unsubscribe under the same lock as handler subscribes, so a client
+	// added meanwhile never ends up on a streamer the ingest left.
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, found := s.clients[c.conn]; !found {
+		return
+	}
+	delete(s.clients, c.conn)
+	if len(s.clients) == 0 {
+		s.ingest.Unsubscribe(s)
+	}
+}
 
-		s.mutex.Lock()
-		hasClients := len(s.clients) > 0
-		s.mutex.Unlock()
+func (s *Streamer) evictAll(reason string) {
+	s.mutex.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.gopValid = false
+	s.mutex.Unlock()
 
-		select {
-		case <-s.done:
-			log.Infof("[%s] FFmpeg-runner stop (shutdown signal).", s.cfg.Name)
-			s.destroyFFmpeg()
-			if ffmpegRunning {
-				<-ffmpegDone
-			}
-			return
-		case err := <-ffmpegDone:
-			log.Errorf("[%s] FFmpeg exited: %v", s.cfg.Name, err)
-			ffmpegRunning = false
-			s.mutex.Lock()
-			s.ffmpegCmd = nil
-			s.mutex.Unlock()
-			if hasClients {
-				log.Infof("[%s] Restarting ffmpeg in %v...", s.cfg.Name, restartDelay)
-				time.Sleep(restartDelay)
-			}
-		case <-time.After(1 * time.Second):
-			switch {
-			case !ffmpegRunning && hasClients:
-				stdout, stderr, err := s.createFFmpeg()
-				if err != nil {
-					log.Errorf("[%s] Failed to start ffmpeg: %v", s.cfg.Name, err)
-					time.Sleep(restartDelay)
-					continue
-				}
-				go s.pipeRunner(stdout, stderr)
-				s.mutex.Lock()
-				cmd := s.ffmpegCmd
-				s.mutex.Unlock()
-				ffmpegRunning = true
-				go func() {
-					ffmpegDone <- cmd.Wait()
-				}()
-				log.Debugf("[%s] FFmpeg started.", s.cfg.Name)
-			case ffmpegRunning && !hasClients:
-				log.Infof("[%s] No clients left, stopping ffmpeg...", s.cfg.Name)
-				s.destroyFFmpeg()
-				ffmpegRunning = false
-			}
-		}
+	for _, c := range clients {
+		s.evict(c, reason)
 	}
 }
 
-func (s *Streamer) createFFmpeg() (io.ReadCloser, io.ReadCloser, error) {
+func (s *Streamer) evict(c *client, reason string) {
+	log.Warnf("[%s] Evicting client: %s [%s]", s.cfg.Name, reason, c.addr)
+	label, _, _ := strings.Cut(reason, ":")
+	metricEvictions.Inc(s.cfg.Name, label)
+	s.removeClient(c)
+}
 
-	if len(s.cfg.FFMpegParams) > 0 {
-		log.Debugf("[%s] Found ffmpeg parameters. Using it.", s.cfg.Name)
-		args := append([]string{}, s.cfg.FFMpegParams...)
-		args = append(args, "pipe:1")
-		s.ffmpegCmd = exec.Command(s.cfg.FFmpegPath, args...)
+func (s *Streamer) Write(b []byte) (int, error) {
+	s.pipeMutex.Lock()
+	defer s.pipeMutex.Unlock()
 
-	} else {
-		log.Infof("[%s] No ffmpeg parameters set. Using default.", s.cfg.Name)
-		s.ffmpegCmd = exec.Command(s.cfg.FFmpegPath,
-			"-loglevel", "warning",
-			"-rtsp_transport", "tcp",
-			"-fflags", "+genpts",
-			"-analyzeduration", "500000",
-			"-probesize", "512k",
-			"-i", s.cfg.RTSPURL,
-			"-map", "0:v",
-			"-c:v", "copy",
-			"-f", "mpegts",
-			"pipe:1",
-		)
-	}
-
-	stdout, err := s.ffmpegCmd.StdoutPipe()
-	stderr, _ := s.ffmpegCmd.StderrPipe()
-	if err != nil {
-		log.Errorf("[%s] Error creating StdoutPipe: %v", s.cfg.Name, err)
-		s.ffmpegCmd = nil
-		return nil, nil, err
-	}
+	s.demuxer.Write(b, s.broadcast)
+	return len(b), nil
+}
 
-	log.Infof("[%s] Starting ffmpeg (PIPE mode)...", s.cfg.Name)
-	if err := s.ffmpegCmd.Start(); err != nil {
-		log.Errorf("[%s] Error starting ffmpeg: %v", s.cfg.Name, err)
-		s.ffmpegCmd = nil
-		return nil, nil, err
+func (s *Streamer) broadcast(chunk *ts.Chunk) {
+	if s.mux != nil {
+		return
 	}
-	return stdout, stderr, nil
+	s.publish(chunk.Data, chunk.Keyframe, s.demuxer.Prime, nil)
 }
 
-func (s *Streamer) pipeRunner(streampipe io.ReadCloser, errpipe io.ReadCloser) {
-	defer func() {
-		streampipe.Close()
-		errpipe.Close()
-	}()
-
-	buf := make([]byte, 8*1024)
-	log.Infof("[%s] Pipe-runner started...", s.cfg.Name)
+func (s *Streamer) publish(data []byte, keyframe bool, primer func(gop []byte) []byte, info []byte) {
+	s.mutex.Lock()
+	if keyframe {
+		s.gop = append(s.gop[:0], data...)
+		s.gopValid = true
+	} else if s.gopValid && len(s.gop)+len(data) <= gopCacheMax {
+		s.gop = append(s.gop, data...)
+	} else {
+		s.gopValid = false
+	}
 
-	go func() {
-		errbuf := make([]byte, 8*1024)
-
-		log.Infof("[%s] FFmpeg-errorpipe-runner start.", s.cfg.Name)
-		for {
-			select {
-			case <-s.done:
-				log.Infof("[%s] FFmpeg-errorpipe-runner stop.", s.cfg.Name)
-				return
-			default:
-				n, err := errpipe.Read(errbuf)
-				if err == nil {
-					if n > 0 {
-						log.Infof("[%s] FFmpeg stderr: %s", s.cfg.Name, string(errbuf[:n]))
-					}
-				} else {
-					log.Debugf("[%s] FFmpeg-errorpipe-runner stop.", s.cfg.Name)
-					return
-				}
+	var prime []byte
+	var evicted []*client
+	msg := wsMessage{typ: websocket.BinaryMessage, data: append([]byte(nil), data...), media: true}
+	for _, c := range s.clients {
+		if !c.primed {
+			if prime == nil && s.gopValid {
+				prime = primer(s.gop)
 			}
-		}
-
-	}()
-
-	log.Infof("[%s] FFmpeg-streampipe-runner start.", s.cfg.Name)
-	for {
-		select {
-		case <-s.done:
-			log.Infof("[%s] FFmpeg-streampipe-runner stop.", s.cfg.Name)
-			return
-		default:
-			n, err := streampipe.Read(buf)
-			if err != nil {
-				log.Errorf("[%s] Error reading from ffmpeg PIPE: %v", s.cfg.Name, err)
-				s.mutex.Lock()
-				for client := range s.clients {
-					client.Close()
-					delete(s.clients, client)
-				}
-				s.mutex.Unlock()
-				log.Infof("[%s] FFmpeg-streampipe-runner stop.", s.cfg.Name)
-				return
+			if prime == nil {
+				continue
 			}
-
-			s.mutex.Lock()
-			conns := make([]*websocket.Conn, 0, len(s.clients))
-			for client := range s.clients {
-				conns = append(conns, client)
+			c.primed = true
+			if info != nil && !c.enqueue(wsMessage{typ: websocket.TextMessage, data: info}, true) {
+				evicted = append(evicted, c)
+				continue
 			}
-			s.mutex.Unlock()
-
-			for _, conn := range conns {
-				if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
-					s.mutex.Lock()
-					conn.Close()
-					delete(s.clients, conn)
-					s.mutex.Unlock()
-				}
+			if !c.enqueue(wsMessage{typ: websocket.BinaryMessage, data: prime}, true) {
+				evicted = append(evicted, c)
 			}
-
+			continue
+		}
+		if !c.enqueue(msg, keyframe) {
+			evicted = append(evicted, c)
 		}
 	}
+	s.mutex.Unlock()
 
+	for _, c := range evicted {
+		s.evict(c, "send queue full")
+	}
 }
 
-func (s *Streamer) destroyFFmpeg() {
+func (s *Streamer) Reset() {
+	s.pipeMutex.Lock()
+	s.demuxer.Reset()
+	if s.mux != nil {
+		s.mux.reset()
+	}
+	s.pipeMutex.Unlock()
+
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	if s.ffmpegCmd != nil {
-		log.Infof("[%s] Destroy ffmpeg process...", s.cfg.Name)
-		if err := s.ffmpegCmd.Process.Kill(); err != nil {
-			log.Errorf("[%s] Failed to terminate ffmpeg-process: %v", s.cfg.Name, err)
-		}
-		s.ffmpegCmd = nil
-		s.restartCount = 0
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.gop = s.gop[:0]
+	s.gopValid = false
+	s.mutex.Unlock()
+
+	for _, c := range clients {
+		s.removeClient(c)
 	}
 }
 
@@ -342,7 +383,29 @@ func (s *Streamer) shutdownHandler() {
 	s.done = make(chan struct{})
 
 	go func() {
-		<-config.SigShutdown
-		s.Close()
+		select {
+		case <-config.SigShutdown:
+			s.Close()
+		case <-s.done:
+		}
 	}()
 }
+
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	global := config.GetConfigGlobal()
+	allowed := func(r *http.Request) bool {
+		return config.OriginAllowed(r.Header.Get("Origin"), config.GetConfigGlobal().Origins)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !allowed(r) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+	}
+	if !authorize(w, r, "events", AUTH_SCOPE_EVENTS, global.AuthTokens, global.AuthSecret) {
+		return
+	}
+	events.Serve(w, r, allowed, "")
+}
@@ -0,0 +1,120 @@
+package streamer_test
+
+import (
+	"bv-streamer/config"
+	"bv-streamer/streamer"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	globalToken  = "global-token"
+	cameraToken  = "garage-token"
+	globalSecret = "global-secret-0123456789"
+	cameraSecret = "garage-secret-0123456789"
+)
+
+func initConfig(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bv-streamer.conf")
+	conf := fmt.Sprintf(`{
+  "loglevel": "error",
+  "ws_port": 1510,
+  "auth_tokens": [%q],
+  "auth_secret": %q,
+  "cameras": [
+    {"name": "Garage", "ws_path": "/garage", "auth_tokens": [%q], "auth_secret": %q},
+    {"name": "Yard", "ws_path": "/yard"}
+  ]
+}`, globalToken, globalSecret, cameraToken, cameraSecret)
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Init(&path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func request(addr, query, bearer string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/garage?"+query, nil)
+	r.RemoteAddr = addr + ":40000"
+	if bearer != "" {
+		r.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	return r
+}
+
+func signed(scope, secret string, expires time.Time) string {
+	return streamer.Sign(scope, secret, expires).Encode()
+}
+
+func TestAuthorize(t *testing.T) {
+	initConfig(t)
+
+	future, past := time.Now().Add(time.Minute), time.Now().Add(-time.Minute)
+	tampered, _ := url.ParseQuery(signed(streamer.CameraScope("Garage"), cameraSecret, future))
+	tampered.Set("expires", fmt.Sprint(future.Add(time.Hour).Unix()))
+
+	tests := []struct {
+		name   string
+		camera string
+		query  string
+		bearer string
+		want   int
+	}{
+		{"no credentials", "Garage", "", "", http.StatusUnauthorized},
+		{"camera bearer", "Garage", "", cameraToken, http.StatusOK},
+		{"global bearer", "Garage", "", globalToken, http.StatusOK},
+		{"query token", "Garage", "token=" + cameraToken, "", http.StatusOK},
+		{"bearer wins over query", "Garage", "token=" + cameraToken, "wrong", http.StatusUnauthorized},
+		{"wrong token", "Garage", "token=wrong", "", http.StatusUnauthorized},
+		{"other camera token", "Yard", "token=" + cameraToken, "", http.StatusUnauthorized},
+		{"signed", "Garage", signed(streamer.CameraScope("Garage"), cameraSecret, future), "", http.StatusOK},
+		{"signed global secret", "Yard", signed(streamer.CameraScope("Yard"), globalSecret, future), "", http.StatusOK},
+		{"expired", "Garage", signed(streamer.CameraScope("Garage"), cameraSecret, past), "", http.StatusUnauthorized},
+		{"tampered expiry", "Garage", tampered.Encode(), "", http.StatusUnauthorized},
+		{"wrong secret", "Garage", signed(streamer.CameraScope("Garage"), globalSecret, future), "", http.StatusUnauthorized},
+		{"wrong camera", "Yard", signed(streamer.CameraScope("Garage"), globalSecret, future), "", http.StatusUnauthorized},
+		{"events scope", "Yard", signed(streamer.AUTH_SCOPE_EVENTS, globalSecret, future), "", http.StatusUnauthorized},
+		{"lowercase scope", "Garage", signed(streamer.CameraScope("garage"), cameraSecret, future), "", http.StatusOK},
+		{"uppercase camera", "GARAGE", signed(streamer.CameraScope("Garage"), cameraSecret, future), "", http.StatusOK},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		r := request(fmt.Sprintf("192.0.2.%d", i+1), tt.query, tt.bearer)
+		ok := streamer.Authorize(w, r, tt.camera)
+		if ok != (tt.want == http.StatusOK) || w.Code != tt.want {
+			t.Errorf("%s: got %v with status %d, want status %d", tt.name, ok, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: missing WWW-Authenticate header", tt.name)
+		}
+	}
+}
+
+func TestAuthorizeLockout(t *testing.T) {
+	initConfig(t)
+
+	for i := range streamer.AUTH_MAX_FAILURES {
+		w := httptest.NewRecorder()
+		if streamer.Authorize(w, request("198.51.100.1", "token=wrong", ""), "Garage") || w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got status %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if streamer.Authorize(w, request("198.51.100.1", "token="+cameraToken, ""), "Garage") || w.Code != http.StatusTooManyRequests {
+		t.Errorf("valid token after %d failures: got status %d, want %d", streamer.AUTH_MAX_FAILURES, w.Code, http.StatusTooManyRequests)
+	}
+
+	w = httptest.NewRecorder()
+	if !streamer.Authorize(w, request("198.51.100.2", "token="+cameraToken, ""), "Garage") {
+		t.Errorf("other address blocked: got status %d", w.Code)
+	}
+}
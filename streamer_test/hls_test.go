@@ -0,0 +1,231 @@
+package streamer_test
+
+import (
+	"bv-streamer/config"
+	"bv-streamer/streamer"
+	"bv-streamer/ts"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tsPackets(pid int, payload []byte) []byte {
+	var out []byte
+	for cc := 0; len(payload) > 0; cc++ {
+		pkt := make([]byte, ts.PACKET_SIZE)
+		pkt[0] = ts.SYNC_BYTE
+		pkt[1] = byte(pid>>8) & 0x1f
+		if cc == 0 {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+		if n := len(payload); n >= 184 {
+			pkt[3] = 0x10 | byte(cc&0x0f)
+			copy(pkt[4:], payload[:184])
+			payload = payload[184:]
+		} else {
+			pkt[3] = 0x30 | byte(cc&0x0f)
+			af := 183 - n
+			pkt[4] = byte(af)
+			for i := 5; i < 5+af; i++ {
+				pkt[i] = 0xff
+			}
+			if af > 0 {
+				pkt[5] = 0x00
+			}
+			copy(pkt[5+af:], payload)
+			payload = nil
+		}
+		out = append(out, pkt...)
+	}
+	return out
+}
+
+// hlsSource writes a 10 fps stream with a keyframe every second.
+type hlsSource struct {
+	hls   *streamer.HLS
+	frame int
+}
+
+func (s *hlsSource) header() {
+	pat := []byte{0x00, 0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00, 0, 0, 0, 0}
+	pmt := []byte{0x00, 0x02, 0xb0, 18, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x00, ts.STREAM_TYPE_H264, 0xe1, 0x00, 0xf0, 0x00, 0, 0, 0, 0}
+	s.hls.Write(append(tsPackets(0, pat), tsPackets(0x1000, pmt)...))
+}
+
+func (s *hlsSource) frames(n int) {
+	for range n {
+		pts := int64(s.frame) * 9000
+		nal := []byte{0x41, 0x9a, 0x22}
+		if s.frame%10 == 0 {
+			nal = []byte{0x65, 0x88, 0x11}
+		}
+		pes := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5,
+			byte(0x21 | (pts>>29)&0x0e), byte(pts >> 22), byte(0x01 | (pts>>14)&0xfe), byte(pts >> 7), byte(0x01 | (pts<<1)&0xfe),
+			0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1}
+		s.hls.Write(tsPackets(0x100, append(pes, nal...)))
+		s.frame++
+	}
+}
+
+// restart simulates an ffmpeg restart with timestamps starting over.
+func (s *hlsSource) restart() {
+	s.hls.Reset()
+	s.frame = 0
+	s.header()
+}
+
+func playlist(t *testing.T, h http.Handler, query string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cam/index.m3u8"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("playlist: got %d %s", w.Code, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+func TestHLSPlaylist(t *testing.T) {
+	h := streamer.NewHLS(&config.ConfigCamera{Name: "cam", HLSSegment: 2, HLSWindow: 3})
+	src := &hlsSource{hls: h}
+	src.header()
+	src.frames(70)
+
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:2.000,
+seg_0.ts
+#EXTINF:2.000,
+seg_1.ts
+#EXTINF:2.000,
+seg_2.ts
+`
+	if got := playlist(t, h, ""); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The open segment is closed on restart and the next one starts a
+	// discontinuity.
+	src.restart()
+	src.frames(41)
+	want = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:3
+#EXTINF:0.900,
+seg_3.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:2.000,
+seg_4.ts
+#EXTINF:2.000,
+seg_5.ts
+`
+	if got := playlist(t, h, ""); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Once the discontinuity leaves the window it is counted.
+	src.frames(60)
+	want = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:6
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXTINF:2.000,
+seg_6.ts
+#EXTINF:2.000,
+seg_7.ts
+#EXTINF:2.000,
+seg_8.ts
+`
+	if got := playlist(t, h, "?token=abc"); got != strings.ReplaceAll(want, ".ts\n", ".ts?token=abc\n") {
+		t.Errorf("got:\n%s\nwant tokens on:\n%s", got, want)
+	}
+}
+
+func TestHLSLowLatency(t *testing.T) {
+	h := streamer.NewHLS(&config.ConfigCamera{Name: "cam", HLSLowLatency: true, HLSSegment: 2, HLSPart: 0.5, HLSWindow: 3})
+	src := &hlsSource{hls: h}
+	src.header()
+	src.frames(27)
+	src.restart()
+	src.frames(6)
+
+	want := `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:2
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500
+#EXT-X-PART-INF:PART-TARGET=0.500
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PART:DURATION=0.500,URI="part_0_0.ts",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="part_0_1.ts"
+#EXT-X-PART:DURATION=0.500,URI="part_0_2.ts"
+#EXT-X-PART:DURATION=0.500,URI="part_0_3.ts"
+#EXTINF:2.000,
+seg_0.ts
+#EXT-X-PART:DURATION=0.500,URI="part_1_0.ts",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.100,URI="part_1_1.ts"
+#EXTINF:0.600,
+seg_1.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-PART:DURATION=0.500,URI="part_2_0.ts",INDEPENDENT=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="part_2_1.ts"
+`
+	if got := playlist(t, h, ""); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, name := range []string{"seg_1.ts", "part_2_0.ts"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cam/"+name, nil))
+		if w.Code != http.StatusOK || w.Body.Len() == 0 || w.Body.Len()%ts.PACKET_SIZE != 0 {
+			t.Errorf("%s: got %d with %d bytes", name, w.Code, w.Body.Len())
+		}
+	}
+}
+
+func TestHLSBlockingReload(t *testing.T) {
+	h := streamer.NewHLS(&config.ConfigCamera{Name: "cam", HLSLowLatency: true, HLSSegment: 2, HLSPart: 0.5, HLSWindow: 3})
+	src := &hlsSource{hls: h}
+	src.header()
+	src.frames(21)
+
+	for _, tt := range []struct {
+		query, want string
+		frames      int
+	}{
+		{"?_HLS_msn=1&_HLS_part=1", `URI="part_1_1.ts"`, 10},
+		{"?_HLS_msn=1", "seg_1.ts\n", 10},
+	} {
+		done := make(chan string)
+		go func() {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cam/index.m3u8"+tt.query, nil))
+			done <- w.Body.String()
+		}()
+		select {
+		case got := <-done:
+			t.Fatalf("%s returned before it was available:\n%s", tt.query, got)
+		case <-time.After(100 * time.Millisecond):
+		}
+		src.frames(tt.frames)
+		select {
+		case got := <-done:
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("%s: %q missing from:\n%s", tt.query, tt.want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s still blocked", tt.query)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cam/index.m3u8?_HLS_msn=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid _HLS_msn: got %d", w.Code)
+	}
+}
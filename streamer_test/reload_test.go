@@ -0,0 +1,115 @@
+package streamer_test
+
+import (
+	"bv-streamer/config"
+	"bv-streamer/streamer"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCameras(t *testing.T, path string, cameras ...string) {
+	t.Helper()
+	ffmpeg, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := make([]string, len(cameras))
+	for i, c := range cameras {
+		list[i] = fmt.Sprintf(`{"rtsp_url": "rtsp://10.0.0.2/main", "ffmpeg_path": %q, %s}`, ffmpeg, c)
+	}
+	conf := `{"loglevel": "error", "ws_port": 1510, "origins": ["https://example.com"], "cameras": [` + strings.Join(list, ",") + `]}`
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func routeStatus(path string) int {
+	w := httptest.NewRecorder()
+	streamer.Routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bv-streamer.conf")
+	writeCameras(t, path,
+		`"name": "Garage", "ws_path": "/garage"`,
+		`"name": "Yard", "ws_path": "/yard"`,
+		`"name": "Drive", "ws_path": "/drive"`,
+		`"name": "Shed", "ws_path": "/shed"`)
+	if err := config.Init(&path); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range config.GetCameras() {
+		if streamer.NewStreamer(c) == nil {
+			t.Fatalf("failed to start %s", c.Name)
+		}
+	}
+	defer func() {
+		for _, name := range []string{"Garage", "Yard", "Drive", "Shed", "Porch"} {
+			if s := streamer.Find(name); s != nil {
+				s.Close()
+			}
+		}
+	}()
+
+	garage, drive := streamer.Find("Garage"), streamer.Find("Drive")
+	if err := streamer.StopCamera("Shed"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Yard is removed, Drive changes, Porch is added and Garage and the
+	// stopped Shed stay as they are.
+	writeCameras(t, path,
+		`"name": "Garage", "ws_path": "/garage"`,
+		`"name": "Drive", "ws_path": "/drive", "ws_format": "fmp4"`,
+		`"name": "Shed", "ws_path": "/shed"`,
+		`"name": "Porch", "ws_path": "/porch"`)
+	if err := streamer.Reload(&path); err != nil {
+		t.Fatal(err)
+	}
+
+	if streamer.Find("Garage") != garage {
+		t.Error("unchanged camera restarted")
+	}
+	if s := streamer.Find("Drive"); s == nil || s == drive {
+		t.Error("changed camera not restarted")
+	}
+	if streamer.Find("Yard") != nil {
+		t.Error("removed camera still running")
+	}
+	if streamer.Find("Porch") == nil {
+		t.Error("added camera not started")
+	}
+	if streamer.Find("Shed") != nil {
+		t.Error("stopped camera started by reload")
+	}
+
+	// Live routes answer the plain GET with a failed WebSocket upgrade.
+	for path, want := range map[string]int{
+		"/garage": http.StatusBadRequest,
+		"/drive":  http.StatusBadRequest,
+		"/porch":  http.StatusBadRequest,
+		"/yard":   http.StatusGone,
+		"/shed":   http.StatusGone,
+	} {
+		if got := routeStatus(path); got != want {
+			t.Errorf("%s: got status %d, want %d", path, got, want)
+		}
+	}
+
+	// A config with errors leaves the running cameras alone.
+	writeCameras(t, path,
+		`"name": "Garage", "ws_path": "/garage"`,
+		`"name": "Porch", "ws_path": "/garage"`)
+	if err := streamer.Reload(&path); err == nil {
+		t.Fatal("expected the reload to be refused")
+	}
+	if streamer.Find("Drive") == nil || streamer.Find("Porch") == nil {
+		t.Error("refused reload stopped cameras")
+	}
+}
@@ -0,0 +1,62 @@
+package streamer_test
+
+import (
+	"bv-streamer/streamer"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func named(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	rt := streamer.NewRouter()
+	get := func(path string) (int, string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code, w.Body.String()
+	}
+	expect := func(path string, code int, body string) {
+		t.Helper()
+		if gotCode, gotBody := get(path); gotCode != code || (body != "" && gotBody != body) {
+			t.Errorf("%s: got %d %q, want %d %q", path, gotCode, gotBody, code, body)
+		}
+	}
+
+	if !rt.Add("/garage", named("garage")) || !rt.Add("/garage/", named("garage hls")) || !rt.Add("/garage/events", named("garage events")) {
+		t.Fatal("failed to add routes")
+	}
+	if rt.Add("/garage", named("duplicate")) {
+		t.Fatal("duplicate route added")
+	}
+
+	expect("/garage", http.StatusOK, "garage")
+	expect("/garage/events", http.StatusOK, "garage events")
+	expect("/garage/index.m3u8", http.StatusOK, "garage hls")
+	expect("/yard", http.StatusNotFound, "")
+	expect("/garagex", http.StatusNotFound, "")
+
+	// Removed cameras answer 410 until their path is used again.
+	rt.Remove("/garage")
+	rt.Remove("/garage/")
+	expect("/garage", http.StatusGone, "")
+	expect("/garage/index.m3u8", http.StatusGone, "")
+	expect("/garage/events", http.StatusOK, "garage events")
+
+	rt.Remove("/yard")
+	expect("/yard", http.StatusNotFound, "")
+
+	rt.Add("/garage", named("garage again"))
+	expect("/garage", http.StatusOK, "garage again")
+
+	// Unknown paths go to the fallback, removed ones do not.
+	rt.SetFallback(named("fallback"))
+	expect("/yard", http.StatusOK, "fallback")
+	expect("/garage/index.m3u8", http.StatusGone, "")
+}
@@ -0,0 +1,203 @@
+package ts
+
+type Frame struct {
+	StreamType byte
+	PTS        int64
+	DTS        int64
+	Keyframe   bool
+	Data       []byte
+}
+
+type Chunk struct {
+	Data       []byte
+	FrameStart bool
+	Keyframe   bool
+	PTS        int64
+}
+
+type Demuxer struct {
+	OnFrame func(*Frame)
+
+	rest       []byte
+	out        []byte
+	pending    []byte
+	pat        []byte
+	pmt        []byte
+	pmtPID     int
+	videoPID   int
+	streamType byte
+
+	pes          []byte
+	holding      bool
+	randomAccess bool
+
+	vps []byte
+	sps []byte
+	pps []byte
+}
+
+func NewDemuxer() *Demuxer {
+	return &Demuxer{
+		pmtPID:   -1,
+		videoPID: -1,
+	}
+}
+
+func (d *Demuxer) Write(b []byte, emit func(*Chunk)) {
+	data := append(d.rest, b...)
+	i := 0
+	for len(data)-i >= PACKET_SIZE {
+		if data[i] != SYNC_BYTE {
+			i++
+			continue
+		}
+		d.packet(Packet(data[i:i+PACKET_SIZE]), emit)
+		i += PACKET_SIZE
+	}
+	d.rest = append(d.rest[:0:0], data[i:]...)
+
+	if len(d.out) > 0 {
+		emit(&Chunk{Data: d.out, PTS: -1})
+		d.out = d.out[:0]
+	}
+}
+
+func (d *Demuxer) Reset() {
+	d.rest = nil
+	d.out = d.out[:0]
+	d.pending = d.pending[:0]
+	d.pes = nil
+	d.holding = false
+	d.randomAccess = false
+}
+
+func (d *Demuxer) Header() []byte {
+	if d.pat == nil || d.pmt == nil {
+		return nil
+	}
+	h := make([]byte, 0, len(d.pat)+len(d.pmt))
+	h = append(h, d.pat...)
+	return append(h, d.pmt...)
+}
+
+func (d *Demuxer) StreamType() byte {
+	return d.streamType
+}
+
+func (d *Demuxer) Params() (vps, sps, pps []byte) {
+	return d.vps, d.sps, d.pps
+}
+
+func (d *Demuxer) packet(pkt Packet, emit func(*Chunk)) {
+	pid := pkt.PID()
+
+	switch {
+	case pid == PID_PAT && pkt.PUSI():
+		d.pat = append(d.pat[:0], pkt...)
+		d.pmtPID = parsePAT(pkt.Payload())
+	case pid == d.pmtPID && pkt.PUSI():
+		d.pmt = append(d.pmt[:0], pkt...)
+		d.videoPID, d.streamType = parsePMT(pkt.Payload())
+	case pid == d.videoPID:
+		if pkt.PUSI() {
+			if d.holding {
+				d.flush(false, emit)
+			}
+			d.finishPES()
+			d.pes = append(d.pes[:0], pkt.Payload()...)
+			d.holding = true
+			d.randomAccess = pkt.RandomAccess()
+		} else if d.pes != nil {
+			d.pes = append(d.pes, pkt.Payload()...)
+		}
+	}
+
+	if d.holding {
+		d.pending = append(d.pending, pkt...)
+		if _, es := pesHeader(d.pes); es != nil {
+			if typ, found := firstVCL(d.streamType, es); found {
+				d.flush(d.randomAccess || IsKeyframe(d.streamType, typ), emit)
+			}
+		}
+	} else {
+		d.out = append(d.out, pkt...)
+	}
+}
+
+func (d *Demuxer) flush(keyframe bool, emit func(*Chunk)) {
+	if len(d.out) > 0 {
+		emit(&Chunk{Data: d.out, PTS: -1})
+		d.out = d.out[:0]
+	}
+	if len(d.pending) > 0 {
+		c := &Chunk{Data: d.pending, FrameStart: true, Keyframe: keyframe, PTS: -1}
+		if hdr, _ := pesHeader(d.pes); hdr != nil {
+			c.PTS, _ = pesTimestamps(hdr)
+		}
+		emit(c)
+		d.pending = d.pending[:0]
+	}
+	d.holding = false
+}
+
+func (d *Demuxer) finishPES() {
+	if len(d.pes) == 0 {
+		return
+	}
+	hdr, es := pesHeader(d.pes)
+	if es == nil {
+		return
+	}
+
+	// The random access flag also marks recovery point I-frames, which IDR
+	// detection alone would miss.
+	f := &Frame{StreamType: d.streamType, Keyframe: d.randomAccess}
+	f.PTS, f.DTS = pesTimestamps(hdr)
+
+	for _, nal := range SplitNALs(es) {
+		switch typ := NALType(d.streamType, nal); {
+		case IsKeyframe(d.streamType, typ):
+			f.Keyframe = true
+		case d.streamType == STREAM_TYPE_H264 && typ == NAL_H264_SPS,
+			d.streamType == STREAM_TYPE_H265 && typ == NAL_H265_SPS:
+			d.sps = append(d.sps[:0:0], nal...)
+		case d.streamType == STREAM_TYPE_H264 && typ == NAL_H264_PPS,
+			d.streamType == STREAM_TYPE_H265 && typ == NAL_H265_PPS:
+			d.pps = append(d.pps[:0:0], nal...)
+		case d.streamType == STREAM_TYPE_H265 && typ == NAL_H265_VPS:
+			d.vps = append(d.vps[:0:0], nal...)
+		}
+	}
+
+	if d.OnFrame != nil {
+		f.Data = es
+		d.OnFrame(f)
+	}
+}
+
+func pesHeader(pes []byte) ([]byte, []byte) {
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return nil, nil
+	}
+	end := 9 + int(pes[8])
+	if len(pes) < end {
+		return nil, nil
+	}
+	return pes[:end], pes[end:]
+}
+
+func pesTimestamps(hdr []byte) (int64, int64) {
+	pts, dts := int64(-1), int64(-1)
+	if flags := hdr[7] >> 6; flags&0x2 != 0 && len(hdr) >= 14 {
+		pts = timestamp(hdr[9:14])
+		dts = pts
+		if flags&0x1 != 0 && len(hdr) >= 19 {
+			dts = timestamp(hdr[14:19])
+		}
+	}
+	return pts, dts
+}
+
+func timestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
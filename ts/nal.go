@@ -0,0 +1,81 @@
+package ts
+
+const (
+	NAL_H264_SLICE = 1
+	NAL_H264_IDR   = 5
+	NAL_H264_SEI   = 6
+	NAL_H264_SPS   = 7
+	NAL_H264_PPS   = 8
+	NAL_H264_AUD   = 9
+
+	NAL_H265_IRAP_FIRST = 16
+	NAL_H265_IRAP_LAST  = 21
+	NAL_H265_VPS        = 32
+	NAL_H265_SPS        = 33
+	NAL_H265_PPS        = 34
+	NAL_H265_AUD        = 35
+)
+
+func SplitNALs(data []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+3 <= len(data); {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				nals = append(nals, trimZeros(data[start:i]))
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 && start < len(data) {
+		nals = append(nals, data[start:])
+	}
+	return nals
+}
+
+func trimZeros(nal []byte) []byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	return nal
+}
+
+func NALType(streamType byte, nal []byte) int {
+	if len(nal) == 0 {
+		return -1
+	}
+	if streamType == STREAM_TYPE_H265 {
+		return int(nal[0]>>1) & 0x3f
+	}
+	return int(nal[0] & 0x1f)
+}
+
+func IsVCL(streamType byte, typ int) bool {
+	if streamType == STREAM_TYPE_H265 {
+		return typ >= 0 && typ < 32
+	}
+	return typ >= NAL_H264_SLICE && typ <= NAL_H264_IDR
+}
+
+func IsKeyframe(streamType byte, typ int) bool {
+	if streamType == STREAM_TYPE_H265 {
+		return typ >= NAL_H265_IRAP_FIRST && typ <= NAL_H265_IRAP_LAST
+	}
+	return typ == NAL_H264_IDR
+}
+
+func firstVCL(streamType byte, data []byte) (int, bool) {
+	for i := 0; i+3 < len(data); i++ {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			typ := NALType(streamType, data[i+3:i+4])
+			if IsVCL(streamType, typ) {
+				return typ, true
+			}
+			i += 2
+		}
+	}
+	return -1, false
+}
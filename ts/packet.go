@@ -0,0 +1,104 @@
+package ts
+
+const (
+	PACKET_SIZE = 188
+	SYNC_BYTE   = 0x47
+
+	PID_PAT = 0x0000
+
+	STREAM_TYPE_H264 = 0x1b
+	STREAM_TYPE_H265 = 0x24
+)
+
+type Packet []byte
+
+func (p Packet) PID() int {
+	return int(p[1]&0x1f)<<8 | int(p[2])
+}
+
+func (p Packet) PUSI() bool {
+	return p[1]&0x40 != 0
+}
+
+func (p Packet) HasAdaptation() bool {
+	return p[3]&0x20 != 0
+}
+
+func (p Packet) HasPayload() bool {
+	return p[3]&0x10 != 0
+}
+
+func (p Packet) CC() byte {
+	return p[3] & 0x0f
+}
+
+func (p Packet) SetCC(cc byte) {
+	p[3] = p[3]&0xf0 | cc&0x0f
+}
+
+func (p Packet) RandomAccess() bool {
+	return p.HasAdaptation() && p[4] > 0 && p[5]&0x40 != 0
+}
+
+func (p Packet) Payload() []byte {
+	if !p.HasPayload() {
+		return nil
+	}
+	off := 4
+	if p.HasAdaptation() {
+		off += 1 + int(p[4])
+	}
+	if off >= len(p) {
+		return nil
+	}
+	return p[off:]
+}
+
+func section(payload []byte) []byte {
+	if len(payload) == 0 {
+		return nil
+	}
+	off := 1 + int(payload[0])
+	if off+3 > len(payload) {
+		return nil
+	}
+	length := int(payload[off+1]&0x0f)<<8 | int(payload[off+2])
+	end := off + 3 + length
+	if end > len(payload) {
+		end = len(payload)
+	}
+	return payload[off:end]
+}
+
+func parsePAT(payload []byte) int {
+	sec := section(payload)
+	if len(sec) < 12 || sec[0] != 0x00 {
+		return -1
+	}
+	for i := 8; i+4 <= len(sec)-4; i += 4 {
+		program := int(sec[i])<<8 | int(sec[i+1])
+		if program != 0 {
+			return int(sec[i+2]&0x1f)<<8 | int(sec[i+3])
+		}
+	}
+	return -1
+}
+
+func parsePMT(payload []byte) (int, byte) {
+	sec := section(payload)
+	if len(sec) < 16 || sec[0] != 0x02 {
+		return -1, 0
+	}
+	infoLen := int(sec[10]&0x0f)<<8 | int(sec[11])
+	for i := 12 + infoLen; i+5 <= len(sec)-4; {
+		streamType := sec[i]
+		pid := int(sec[i+1]&0x1f)<<8 | int(sec[i+2])
+		esLen := int(sec[i+3]&0x0f)<<8 | int(sec[i+4])
+		switch streamType {
+		case STREAM_TYPE_H264, STREAM_TYPE_H265:
+			return pid, streamType
+		}
+		i += 5 + esLen
+	}
+	return -1, 0
+}
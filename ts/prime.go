@@ -0,0 +1,123 @@
+package ts
+
+import "encoding/binary"
+
+// Prime returns PAT, PMT and the GOP for a new client, with the cached
+// parameter sets inserted into the keyframe so it decodes even when the
+// camera sends them only once or on IDR frames. Nil without PAT/PMT.
+func (d *Demuxer) Prime(gop []byte) []byte {
+	h := d.Header()
+	if h == nil {
+		return nil
+	}
+	return append(h, d.insertParams(gop)...)
+}
+
+func (d *Demuxer) insertParams(gop []byte) []byte {
+	var params []byte
+	for _, nal := range [][]byte{d.vps, d.sps, d.pps} {
+		if len(nal) > 0 {
+			params = append(append(params, 0, 0, 0, 1), nal...)
+		}
+	}
+	if params == nil || len(gop) < PACKET_SIZE {
+		return gop
+	}
+	first := Packet(gop[:PACKET_SIZE])
+	if first.PID() != d.videoPID || !first.PUSI() {
+		return gop
+	}
+	payload := first.Payload()
+	hdr, es := pesHeader(payload)
+	if hdr == nil {
+		return gop
+	}
+
+	at := len(hdr) + afterAUD(d.streamType, es)
+	grown := make([]byte, 0, len(payload)+len(params))
+	grown = append(append(append(grown, payload[:at]...), params...), payload[at:]...)
+	if n := int(binary.BigEndian.Uint16(grown[4:6])); n > 0 {
+		if n += len(params); n > 0xffff {
+			n = 0
+		}
+		binary.BigEndian.PutUint16(grown[4:6], uint16(n))
+	}
+
+	// The first packet keeps its header and adaptation field, the payload
+	// that no longer fits moves into stuffed packets right after it.
+	space := len(payload)
+	out := make([]byte, 0, len(gop)+len(params)+2*PACKET_SIZE)
+	out = append(append(out, first[:PACKET_SIZE-space]...), grown[:space]...)
+	for rest := grown[space:]; len(rest) > 0; {
+		pkt := make([]byte, PACKET_SIZE)
+		pkt[0] = SYNC_BYTE
+		pkt[1] = first[1] &^ 0x40
+		pkt[2] = first[2]
+		n := min(len(rest), PACKET_SIZE-4)
+		if n == PACKET_SIZE-4 {
+			pkt[3] = 0x10
+		} else {
+			pkt[3] = 0x30
+			stuffing := PACKET_SIZE - 5 - n
+			pkt[4] = byte(stuffing)
+			if stuffing > 0 {
+				pkt[5] = 0x00
+				for i := 6; i < 5+stuffing; i++ {
+					pkt[i] = 0xff
+				}
+			}
+		}
+		copy(pkt[PACKET_SIZE-n:], rest[:n])
+		rest = rest[n:]
+		out = append(out, pkt...)
+	}
+	out = append(out, gop[PACKET_SIZE:]...)
+
+	// Count continuity back from the last video packet, so the prime runs
+	// straight into the live chunks that follow it.
+	var video []Packet
+	for i := 0; i+PACKET_SIZE <= len(out); i += PACKET_SIZE {
+		if pkt := Packet(out[i : i+PACKET_SIZE]); pkt.PID() == d.videoPID {
+			video = append(video, pkt)
+		}
+	}
+	last := lastCC(gop, d.videoPID)
+	for i, pkt := range video {
+		pkt.SetCC(last - byte(len(video)-1-i))
+	}
+	return out
+}
+
+func lastCC(data []byte, pid int) byte {
+	var cc byte
+	for i := 0; i+PACKET_SIZE <= len(data); i += PACKET_SIZE {
+		if pkt := Packet(data[i : i+PACKET_SIZE]); pkt.PID() == pid {
+			cc = pkt.CC()
+		}
+	}
+	return cc
+}
+
+// afterAUD returns the offset behind a leading access unit delimiter, which
+// has to stay the first NAL unit of the access unit.
+func afterAUD(streamType byte, es []byte) int {
+	start := 0
+	switch {
+	case len(es) >= 4 && es[0] == 0 && es[1] == 0 && es[2] == 0 && es[3] == 1:
+		start = 4
+	case len(es) >= 3 && es[0] == 0 && es[1] == 0 && es[2] == 1:
+		start = 3
+	default:
+		return 0
+	}
+	typ := NALType(streamType, es[start:])
+	if streamType == STREAM_TYPE_H265 && typ != NAL_H265_AUD || streamType != STREAM_TYPE_H265 && typ != NAL_H264_AUD {
+		return 0
+	}
+	for i := start + 1; i+3 <= len(es); i++ {
+		if es[i] == 0 && es[i+1] == 0 && (es[i+2] == 1 || i+3 < len(es) && es[i+2] == 0 && es[i+3] == 1) {
+			return i
+		}
+	}
+	return 0
+}
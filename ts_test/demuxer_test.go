@@ -0,0 +1,232 @@
+package ts_test
+
+import (
+	"bv-streamer/ts"
+	"bytes"
+	"testing"
+)
+
+func tsPackets(pid int, payload []byte, pusi bool) []byte {
+	var out []byte
+	cc := 0
+	for first := true; len(payload) > 0 || first; first = false {
+		pkt := make([]byte, ts.PACKET_SIZE)
+		pkt[0] = ts.SYNC_BYTE
+		pkt[1] = byte(pid>>8) & 0x1f
+		if first && pusi {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+		n := len(payload)
+		if n >= 184 {
+			pkt[3] = 0x10 | byte(cc&0x0f)
+			copy(pkt[4:], payload[:184])
+			payload = payload[184:]
+		} else {
+			pkt[3] = 0x30 | byte(cc&0x0f)
+			af := 183 - n
+			pkt[4] = byte(af)
+			if af > 0 {
+				pkt[5] = 0x00
+				for i := 6; i < 5+af; i++ {
+					pkt[i] = 0xff
+				}
+			}
+			copy(pkt[5+af:], payload)
+			payload = nil
+		}
+		cc++
+		out = append(out, pkt...)
+	}
+	return out
+}
+
+func psi(section []byte) []byte {
+	return append([]byte{0x00}, section...)
+}
+
+func pes(pts int64, es []byte) []byte {
+	h := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5,
+		byte(0x21 | (pts>>29)&0x0e), byte(pts >> 22), byte(0x01 | (pts>>14)&0xfe), byte(pts >> 7), byte(0x01 | (pts<<1)&0xfe)}
+	return append(h, es...)
+}
+
+func Test_demuxer(t *testing.T) {
+	pat := psi([]byte{0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00, 0, 0, 0, 0})
+	pmt := psi([]byte{0x02, 0xb0, 18, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x00, ts.STREAM_TYPE_H264, 0xe1, 0x00, 0xf0, 0x00, 0, 0, 0, 0})
+
+	sps := []byte{0x67, 0x42, 0xc0, 0x1e, 0xda}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	sei := append([]byte{0x06, 0x05}, bytes.Repeat([]byte{0xaa}, 400)...)
+	idr := append([]byte{0x65, 0x88}, bytes.Repeat([]byte{0x11}, 300)...)
+	slice := append([]byte{0x41, 0x9a}, bytes.Repeat([]byte{0x22}, 100)...)
+
+	var key []byte
+	for _, nal := range [][]byte{{0x09, 0xf0}, sps, pps, sei, idr} {
+		key = append(key, 0, 0, 0, 1)
+		key = append(key, nal...)
+	}
+	delta := append([]byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1}, slice...)
+
+	var stream []byte
+	stream = append(stream, tsPackets(0, pat, true)...)
+	stream = append(stream, tsPackets(0x1000, pmt, true)...)
+	stream = append(stream, tsPackets(0x100, pes(3000, delta), true)...)
+	keyStart := len(stream)
+	stream = append(stream, tsPackets(0x100, pes(6000, key), true)...)
+	stream = append(stream, tsPackets(0x100, pes(9000, delta), true)...)
+
+	d := ts.NewDemuxer()
+	var frames []*ts.Frame
+	d.OnFrame = func(f *ts.Frame) {
+		frames = append(frames, &ts.Frame{PTS: f.PTS, Keyframe: f.Keyframe})
+	}
+
+	var out []byte
+	keyAt := -1
+	garbage := []byte{0x00, 0x12}
+	input := append(garbage, stream...)
+	for i := 0; i < len(input); i += 100 {
+		end := min(i+100, len(input))
+		d.Write(input[i:end], func(c *ts.Chunk) {
+			if len(c.Data)%ts.PACKET_SIZE != 0 {
+				t.Fatalf("unaligned output of %d bytes", len(c.Data))
+			}
+			if c.Keyframe {
+				if keyAt >= 0 {
+					t.Fatal("more than one keyframe")
+				}
+				if c.PTS != 6000 {
+					t.Fatalf("keyframe pts %d, want 6000", c.PTS)
+				}
+				keyAt = len(out)
+			}
+			out = append(out, c.Data...)
+		})
+	}
+	d.Write(tsPackets(0x100, pes(12000, delta), true), func(*ts.Chunk) {})
+
+	if keyAt != keyStart {
+		t.Fatalf("keyframe at %d, want %d", keyAt, keyStart)
+	}
+	if !bytes.Equal(out, stream[:len(out)]) {
+		t.Fatal("output differs from aligned input")
+	}
+	if h := d.Header(); !bytes.Equal(h, stream[:2*ts.PACKET_SIZE]) {
+		t.Fatal("unexpected PAT/PMT header")
+	}
+	if _, gotSPS, gotPPS := d.Params(); !bytes.Equal(gotSPS, sps) || !bytes.Equal(gotPPS, pps) {
+		t.Fatalf("unexpected params %x %x", gotSPS, gotPPS)
+	}
+	if len(frames) != 3 || frames[1].PTS != 6000 || !frames[1].Keyframe || frames[0].Keyframe {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+}
+
+func randomAccess(pkts []byte) []byte {
+	if pkts[3]&0x20 == 0 || pkts[4] == 0 {
+		panic("first packet has no adaptation field")
+	}
+	pkts[5] |= 0x40
+	return pkts
+}
+
+func annexB(nals ...[]byte) []byte {
+	var out []byte
+	for _, nal := range nals {
+		out = append(append(out, 0, 0, 0, 1), nal...)
+	}
+	return out
+}
+
+func Test_recoveryPointPrime(t *testing.T) {
+	pat := psi([]byte{0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00, 0, 0, 0, 0})
+	pmt := psi([]byte{0x02, 0xb0, 18, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x00, ts.STREAM_TYPE_H264, 0xe1, 0x00, 0xf0, 0x00, 0, 0, 0, 0})
+
+	aud := []byte{0x09, 0xf0}
+	sps := append([]byte{0x67, 0x64, 0x00, 0x28}, bytes.Repeat([]byte{0xac}, 60)...)
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+	idr := append([]byte{0x65, 0x88}, bytes.Repeat([]byte{0x11}, 300)...)
+	iSlice := append([]byte{0x21, 0x88}, bytes.Repeat([]byte{0x33}, 120)...)
+	pSlice := append([]byte{0x41, 0x9a}, bytes.Repeat([]byte{0x22}, 100)...)
+
+	var stream []byte
+	stream = append(stream, tsPackets(0, pat, true)...)
+	stream = append(stream, tsPackets(0x1000, pmt, true)...)
+	stream = append(stream, tsPackets(0x100, pes(3000, annexB(aud, sps, pps, idr)), true)...)
+	stream = append(stream, tsPackets(0x100, pes(6000, annexB(aud, pSlice)), true)...)
+	// A recovery point I-frame without parameter sets, marked only by the
+	// random access indicator.
+	stream = append(stream, randomAccess(tsPackets(0x100, pes(9000, annexB(aud, iSlice)), true))...)
+	stream = append(stream, tsPackets(0x100, pes(12000, annexB(aud, pSlice)), true)...)
+	stream = append(stream, tsPackets(0x100, pes(15000, annexB(aud, pSlice)), true)...)
+
+	d := ts.NewDemuxer()
+	var keyframes []int64
+	d.OnFrame = func(f *ts.Frame) {
+		if f.Keyframe {
+			keyframes = append(keyframes, f.PTS)
+		}
+	}
+	var gop []byte
+	var chunkKeys []int64
+	d.Write(stream, func(c *ts.Chunk) {
+		if c.Keyframe {
+			chunkKeys = append(chunkKeys, c.PTS)
+			gop = gop[:0]
+		}
+		gop = append(gop, c.Data...)
+	})
+	if len(chunkKeys) != 2 || chunkKeys[1] != 9000 {
+		t.Fatalf("keyframe chunks at %v, want [3000 9000]", chunkKeys)
+	}
+	if len(keyframes) != 2 || keyframes[1] != 9000 {
+		t.Fatalf("keyframe frames at %v, want [3000 9000]", keyframes)
+	}
+
+	prime := d.Prime(gop)
+	if !bytes.Equal(prime[:2*ts.PACKET_SIZE], d.Header()) {
+		t.Fatal("prime does not start with PAT/PMT")
+	}
+	if len(prime)%ts.PACKET_SIZE != 0 {
+		t.Fatalf("unaligned prime of %d bytes", len(prime))
+	}
+
+	// The prime's continuity counters have to run into the live stream.
+	var ccs []byte
+	for i := 0; i < len(prime); i += ts.PACKET_SIZE {
+		if pkt := ts.Packet(prime[i : i+ts.PACKET_SIZE]); pkt.PID() == 0x100 {
+			ccs = append(ccs, pkt.CC())
+		}
+	}
+	for i := 1; i < len(ccs); i++ {
+		if ccs[i] != (ccs[i-1]+1)&0x0f {
+			t.Fatalf("continuity broken in prime: %v", ccs)
+		}
+	}
+	if want := ts.Packet(gop[len(gop)-ts.PACKET_SIZE:]).CC(); ccs[len(ccs)-1] != want {
+		t.Fatalf("prime ends with cc %d, want %d", ccs[len(ccs)-1], want)
+	}
+
+	fresh := ts.NewDemuxer()
+	var frames [][][]byte
+	fresh.OnFrame = func(f *ts.Frame) {
+		if f.Keyframe {
+			frames = append(frames, ts.SplitNALs(bytes.Clone(f.Data)))
+		}
+	}
+	fresh.Write(prime, func(*ts.Chunk) {})
+	fresh.Write(tsPackets(0x100, pes(18000, annexB(aud, pSlice)), true), func(*ts.Chunk) {})
+	if len(frames) != 1 {
+		t.Fatalf("got %d keyframes from the prime, want 1", len(frames))
+	}
+	want := [][]byte{aud, sps, pps, iSlice}
+	if len(frames[0]) != len(want) {
+		t.Fatalf("keyframe has %d NAL units, want %d", len(frames[0]), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(frames[0][i], want[i]) {
+			t.Fatalf("NAL unit %d is %x, want %x", i, frames[0][i], want[i])
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type delivery struct {
+	Hook     string            `json:"hook"`
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     json.RawMessage   `json:"body"`
+	Timeout  int               `json:"timeout"`
+	Created  time.Time         `json:"created"`
+	Attempts int               `json:"attempts"`
+	Next     time.Time         `json:"next"`
+}
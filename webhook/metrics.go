@@ -0,0 +1,8 @@
+package webhook
+
+import "bv-streamer/metrics"
+
+var (
+	metricDeliveries = metrics.NewCounter("bv_webhook_deliveries_total", "Webhook delivery attempts by result (ok, retry, failed, expired).", "hook", "result")
+	metricQueued     = metrics.NewGauge("bv_webhook_queued", "Webhook deliveries waiting in the queue.")
+)
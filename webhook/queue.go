@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"bv-streamer/log"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (d *Dispatcher) store(name string, dl *delivery) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(d.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(d.dir, name))
+}
+
+func (d *Dispatcher) load(name string) (*delivery, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	dl := &delivery{}
+	return dl, json.Unmarshal(data, dl)
+}
+
+func (d *Dispatcher) remove(name string) {
+	if err := os.Remove(filepath.Join(d.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Webhook queue: %v", err)
+	}
+}
+
+func (d *Dispatcher) flush(ctx context.Context) time.Duration {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		log.Errorf("Webhook queue: %v", err)
+		return WEBHOOK_RETRY_MAX
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	wait := WEBHOOK_RETRY_MAX
+	queued := 0
+	down := make(map[string]bool)
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+
+		dl, err := d.load(name)
+		if err != nil {
+			log.Errorf("Webhook queue: dropping unreadable %s: %v", name, err)
+			d.remove(name)
+			continue
+		}
+
+		now := time.Now()
+		if now.Sub(dl.Created) > d.maxAge {
+			log.Warnf("Webhook %s: giving up on delivery from %s after %d attempts.", dl.Hook, dl.Created.Format(time.RFC3339), dl.Attempts)
+			metricDeliveries.Inc(dl.Hook, "expired")
+			d.remove(name)
+			continue
+		}
+
+		host := ""
+		if u, err := url.Parse(dl.URL); err == nil {
+			host = u.Host
+		}
+		if dl.Next.After(now) || down[host] {
+			down[host] = true
+			queued++
+			if next := max(dl.Next.Sub(now), d.retry); next < wait {
+				wait = next
+			}
+			continue
+		}
+
+		retry, err := d.send(ctx, dl)
+		switch {
+		case err == nil:
+			log.Debugf("Webhook %s: delivered.", dl.Hook)
+			metricDeliveries.Inc(dl.Hook, "ok")
+			d.remove(name)
+		case !retry:
+			log.Errorf("Webhook %s: %v, dropping delivery.", dl.Hook, err)
+			metricDeliveries.Inc(dl.Hook, "failed")
+			d.remove(name)
+		default:
+			down[host] = true
+			backoff := min(d.retry<<min(dl.Attempts, 10), WEBHOOK_RETRY_MAX)
+			dl.Attempts++
+			dl.Next = time.Now().Add(backoff)
+			log.Warnf("Webhook %s: %v, retry %d in %v.", dl.Hook, err, dl.Attempts, backoff)
+			metricDeliveries.Inc(dl.Hook, "retry")
+			if err := d.store(name, dl); err != nil {
+				log.Errorf("Webhook queue: %v", err)
+			}
+			queued++
+			wait = min(wait, backoff)
+		}
+	}
+	metricQueued.Set(float64(queued))
+	return wait
+}
+
+func (d *Dispatcher) send(ctx context.Context, dl *delivery) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(dl.Timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, dl.Method, dl.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range dl.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("%s %s: %s", dl.Method, dl.URL, resp.Status)
+	default:
+		return false, fmt.Errorf("%s %s: %s", dl.Method, dl.URL, resp.Status)
+	}
+}
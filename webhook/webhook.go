@@ -0,0 +1,294 @@
+package webhook
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/log"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	WEBHOOK_TIMEOUT_DEFAULT = 10
+	WEBHOOK_MAX_AGE_DEFAULT = 24
+	WEBHOOK_RETRY_MIN       = 5 * time.Second
+	WEBHOOK_RETRY_MAX       = 10 * time.Minute
+	WEBHOOK_EVENTS_BUFFER   = 64
+	WEBHOOK_QUEUE_DEFAULT   = "bv-streamer-webhooks"
+
+	TYPE_CUSTOM = "custom"
+	TYPE_NTFY   = "ntfy"
+	TYPE_GOTIFY = "gotify"
+)
+
+var templates = map[string]string{
+	TYPE_CUSTOM: `{"event":{{json .Event}},"camera":{{json .Camera}},"class":{{json .Class}},"classes":{{json .Classes}},` +
+		`"time":{{json .Time}},"start":{{json .Start}},"duration":{{json .Duration}},"recording":{{json .Recording}},"link":{{json .Link}}}`,
+	TYPE_NTFY: `{"topic":{{json .Topic}},"title":{{json .Title}},"message":{{json .Message}},` +
+		`{{if eq .Event "alarm_start"}}"tags":["rotating_light"],"priority":4{{else}}"tags":["white_check_mark"],"priority":2{{end}}` +
+		`{{if .Link}},"click":{{json .Link}}{{end}}}`,
+	TYPE_GOTIFY: `{"title":{{json .Title}},"message":{{json .Message}},"priority":{{if eq .Event "alarm_start"}}8{{else}}2{{end}}` +
+		`{{if .Link}},"extras":{"client::notification":{"click":{"url":{{json .Link}}}}}{{end}}}`,
+}
+
+type Payload struct {
+	Event     string
+	Camera    string
+	Class     string
+	Classes   []string
+	Time      time.Time
+	Start     time.Time
+	Duration  float64
+	Recording string
+	Link      string
+	Topic     string
+	Title     string
+	Message   string
+}
+
+type hook struct {
+	cfg   *config.ConfigWebhook
+	kind  string
+	url   string
+	topic string
+	tmpl  *template.Template
+}
+
+type Dispatcher struct {
+	hooks  []*hook
+	dir    string
+	maxAge time.Duration
+	retry  time.Duration
+	public string
+	client *http.Client
+
+	evs         <-chan events.Event
+	unsubscribe func()
+	wake        chan struct{}
+	seq         uint64
+}
+
+func NewDispatcher(g *config.ConfigGlobal) (*Dispatcher, error) {
+	d := &Dispatcher{
+		dir:    g.WebhookQueue,
+		maxAge: time.Duration(g.WebhookMaxAge) * time.Hour,
+		retry:  time.Duration(g.WebhookRetry) * time.Second,
+		public: strings.TrimSuffix(g.PublicURL, "/"),
+		client: &http.Client{},
+		wake:   make(chan struct{}, 1),
+	}
+	if d.dir == "" {
+		d.dir = filepath.Join(os.TempDir(), WEBHOOK_QUEUE_DEFAULT)
+	}
+	if d.maxAge == 0 {
+		d.maxAge = WEBHOOK_MAX_AGE_DEFAULT * time.Hour
+	}
+	if d.retry == 0 {
+		d.retry = WEBHOOK_RETRY_MIN
+	}
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	for _, c := range g.Webhooks {
+		h := &hook{cfg: c, kind: strings.ToLower(c.Type), url: c.URL}
+		if h.kind == "" {
+			h.kind = TYPE_CUSTOM
+		}
+		if h.kind == TYPE_NTFY {
+			u, err := url.Parse(c.URL)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %s: %w", c.Name, err)
+			}
+			path := strings.TrimSuffix(u.Path, "/")
+			i := strings.LastIndex(path, "/")
+			h.topic = path[i+1:]
+			u.Path = path[:i+1]
+			h.url = u.String()
+		}
+
+		text := c.Template
+		if text == "" {
+			text = templates[h.kind]
+		}
+		tmpl, err := template.New(c.Name).Funcs(config.TemplateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %w", c.Name, err)
+		}
+		h.tmpl = tmpl
+		d.hooks = append(d.hooks, h)
+	}
+
+	d.evs, d.unsubscribe = events.Subscribe(WEBHOOK_EVENTS_BUFFER)
+	return d, nil
+}
+
+// Run persists events and delivers the queue in separate goroutines, so a
+// slow endpoint never keeps alarm events from reaching the disk queue.
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	defer d.unsubscribe()
+
+	persisted := make(chan struct{})
+	go func() {
+		defer close(persisted)
+		d.persist(stop)
+	}()
+	d.deliver(stop)
+	<-persisted
+}
+
+func (d *Dispatcher) persist(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			for {
+				select {
+				case ev := <-d.evs:
+					d.enqueue(ev)
+				default:
+					return
+				}
+			}
+		case ev := <-d.evs:
+			d.enqueue(ev)
+			select {
+			case d.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Reset(d.flush(ctx))
+	}
+}
+
+func (d *Dispatcher) enqueue(ev events.Event) {
+	if ev.Type != events.EVENT_ALARM_START && ev.Type != events.EVENT_ALARM_STOP {
+		return
+	}
+
+	p := d.payload(ev)
+	d.seq++
+	for i, h := range d.hooks {
+		if len(h.cfg.Events) > 0 && !slices.Contains(h.cfg.Events, string(ev.Type)) {
+			continue
+		}
+		if len(h.cfg.Cameras) > 0 && !slices.ContainsFunc(h.cfg.Cameras, func(c string) bool { return strings.EqualFold(c, ev.Camera) }) {
+			continue
+		}
+
+		p.Topic = h.topic
+		var body bytes.Buffer
+		if err := h.tmpl.Execute(&body, p); err != nil {
+			log.Errorf("[%s] Webhook %s: %v", ev.Camera, h.cfg.Name, err)
+			continue
+		}
+		if !json.Valid(body.Bytes()) {
+			log.Errorf("[%s] Webhook %s: template did not produce valid JSON.", ev.Camera, h.cfg.Name)
+			continue
+		}
+
+		dl := &delivery{
+			Hook:    h.cfg.Name,
+			Method:  strings.ToUpper(h.cfg.Method),
+			URL:     h.url,
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    body.Bytes(),
+			Timeout: h.cfg.Timeout,
+			Created: ev.Time,
+			Next:    ev.Time,
+		}
+		if dl.Method == "" {
+			dl.Method = http.MethodPost
+		}
+		if dl.Timeout == 0 {
+			dl.Timeout = WEBHOOK_TIMEOUT_DEFAULT
+		}
+		if h.cfg.Token != "" {
+			if h.kind == TYPE_GOTIFY {
+				dl.Headers["X-Gotify-Key"] = h.cfg.Token
+			} else {
+				dl.Headers["Authorization"] = "Bearer " + h.cfg.Token
+			}
+		}
+		for k, v := range h.cfg.Headers {
+			dl.Headers[k] = v
+		}
+
+		name := fmt.Sprintf("%d-%06d-%02d-%s.json", ev.Time.UnixNano(), d.seq%1000000, i, fileSafe(h.cfg.Name))
+		if err := d.store(name, dl); err != nil {
+			log.Errorf("[%s] Webhook %s: could not queue delivery: %v", ev.Camera, h.cfg.Name, err)
+		}
+	}
+}
+
+func (d *Dispatcher) payload(ev events.Event) Payload {
+	p := Payload{Event: string(ev.Type), Camera: ev.Camera, Time: ev.Time}
+	classes, _ := ev.Data["classes"].([]alarm.Class)
+	for _, c := range classes {
+		p.Classes = append(p.Classes, string(c))
+	}
+	if len(p.Classes) > 0 {
+		p.Class = p.Classes[0]
+	}
+	p.Start, _ = ev.Data["start"].(time.Time)
+	p.Duration, _ = ev.Data["duration"].(float64)
+	p.Recording, _ = ev.Data["id"].(string)
+	if d.public != "" && p.Recording != "" {
+		p.Link = d.public + "/api/cameras/" + url.PathEscape(ev.Camera) + "/recordings/" + url.PathEscape(p.Recording)
+	}
+
+	what := "Alarm"
+	if len(p.Classes) > 0 {
+		what = strings.Join(p.Classes, ", ")
+	}
+	if ev.Type == events.EVENT_ALARM_START {
+		p.Title = fmt.Sprintf("%s: %s detected", ev.Camera, what)
+		p.Message = fmt.Sprintf("%s detected on %s at %s.", what, ev.Camera, ev.Time.Format("15:04:05"))
+	} else {
+		p.Title = fmt.Sprintf("%s: alarm ended", ev.Camera)
+		p.Message = fmt.Sprintf("Alarm on %s ended after %s (%s).", ev.Camera, time.Duration(p.Duration)*time.Second, what)
+	}
+	return p
+}
+
+func fileSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,246 @@
+package webhook_test
+
+import (
+	"bv-streamer/alarm"
+	"bv-streamer/config"
+	"bv-streamer/events"
+	"bv-streamer/webhook"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type request struct {
+	path   string
+	auth   string
+	fields map[string]any
+}
+
+type endpoint struct {
+	mu       sync.Mutex
+	failing  bool
+	stall    chan struct{}
+	attempts int
+	received []request
+	server   *httptest.Server
+}
+
+func newEndpoint(t *testing.T) *endpoint {
+	e := &endpoint{failing: true}
+	e.server = httptest.NewServer(http.HandlerFunc(e.handle))
+	t.Cleanup(e.server.Close)
+	return e
+}
+
+func (e *endpoint) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	e.mu.Lock()
+	stall := e.stall
+	e.attempts++
+	e.mu.Unlock()
+	if stall != nil {
+		<-stall
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	req := request{path: r.URL.Path, auth: r.Header.Get("Authorization")}
+	json.Unmarshal(body, &req.fields)
+	e.received = append(e.received, req)
+}
+
+func (e *endpoint) requests() []request {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]request{}, e.received...)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func queued(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func start(t *testing.T, g *config.ConfigGlobal) func() {
+	d, err := webhook.NewDispatcher(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		d.Run(stop)
+		close(finished)
+	}()
+	return func() {
+		close(stop)
+		<-finished
+	}
+}
+
+func initConfig(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bv-streamer.conf")
+	if err := os.WriteFile(path, []byte(`{"loglevel": "error", "ws_port": 1510}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Init(&path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueRetriesAcrossRestarts(t *testing.T) {
+	initConfig(t)
+
+	e := newEndpoint(t)
+	dir := t.TempDir()
+	g := &config.ConfigGlobal{
+		PublicURL:    "https://cams.example/",
+		WebhookQueue: dir,
+		WebhookRetry: 1,
+		Webhooks: []*config.ConfigWebhook{
+			{Name: "custom", URL: e.server.URL + "/hook"},
+			{Name: "phone", Type: "ntfy", URL: e.server.URL + "/alerts", Token: "tk", Events: []string{"alarm_start"}},
+		},
+	}
+	for _, issue := range g.Validate() {
+		if strings.HasPrefix(issue.Field, "webhook") || issue.Field == "public_url" {
+			t.Fatalf("unexpected issue %v", issue)
+		}
+	}
+
+	stop := start(t, g)
+	started := time.Now()
+	events.Publish(events.EVENT_ALARM_START, "Garage", map[string]any{
+		"start":   started,
+		"classes": []alarm.Class{alarm.CLASS_PEOPLE},
+		"id":      "rec_Garage_1",
+	})
+	events.Publish(events.EVENT_MOTION_START, "Garage", nil)
+	waitFor(t, "failed attempts", func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.attempts >= 2
+	})
+	stop()
+
+	if files := queued(t, dir); len(files) != 2 {
+		t.Fatalf("queued %d deliveries after failures, want 2", len(files))
+	}
+
+	e.mu.Lock()
+	e.failing = false
+	e.mu.Unlock()
+
+	stop = start(t, g)
+	defer stop()
+	events.Publish(events.EVENT_ALARM_STOP, "Garage", map[string]any{
+		"start":    started,
+		"classes":  []alarm.Class{alarm.CLASS_PEOPLE},
+		"id":       "rec_Garage_1",
+		"duration": 42.0,
+	})
+	waitFor(t, "deliveries", func() bool { return len(e.requests()) == 3 && len(queued(t, dir)) == 0 })
+
+	var custom, ntfy []request
+	for _, r := range e.requests() {
+		if r.path == "/hook" {
+			custom = append(custom, r)
+		} else {
+			ntfy = append(ntfy, r)
+		}
+	}
+	if len(custom) != 2 || custom[0].fields["event"] != "alarm_start" || custom[1].fields["event"] != "alarm_stop" {
+		t.Fatalf("unexpected custom deliveries %+v", custom)
+	}
+	start := custom[0].fields
+	if start["camera"] != "Garage" || start["class"] != "people" ||
+		start["link"] != "https://cams.example/api/cameras/Garage/recordings/rec_Garage_1" {
+		t.Errorf("unexpected alarm_start payload %v", start)
+	}
+	if custom[1].fields["duration"] != 42.0 {
+		t.Errorf("unexpected alarm_stop payload %v", custom[1].fields)
+	}
+
+	if len(ntfy) != 1 {
+		t.Fatalf("got %d ntfy deliveries, want 1", len(ntfy))
+	}
+	if ntfy[0].path != "/" || ntfy[0].auth != "Bearer tk" || ntfy[0].fields["topic"] != "alerts" ||
+		!strings.Contains(ntfy[0].fields["title"].(string), "people") {
+		t.Errorf("unexpected ntfy delivery %+v", ntfy[0])
+	}
+}
+
+func TestStalledEndpointDoesNotDropEvents(t *testing.T) {
+	initConfig(t)
+
+	e := newEndpoint(t)
+	e.failing = false
+	e.stall = make(chan struct{})
+	var once sync.Once
+	release := func() { once.Do(func() { close(e.stall) }) }
+	t.Cleanup(release)
+	dir := t.TempDir()
+	g := &config.ConfigGlobal{
+		WebhookQueue: dir,
+		Webhooks:     []*config.ConfigWebhook{{Name: "slow", URL: e.server.URL + "/hook", Timeout: 60}},
+	}
+	stop := start(t, g)
+	defer stop()
+
+	publish := func(n int) {
+		events.Publish(events.EVENT_ALARM_START, "Garage", map[string]any{"id": fmt.Sprintf("rec_%03d", n)})
+	}
+	publish(0)
+	waitFor(t, "stalled delivery", func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.attempts == 1
+	})
+
+	// Bursts below the subscription buffer, but far more events in total
+	// than it holds while the first delivery is still in flight.
+	const bursts, size = 5, 40
+	total := 1
+	for range bursts {
+		for range size {
+			publish(total)
+			total++
+		}
+		waitFor(t, "persisted burst", func() bool { return len(queued(t, dir)) == total })
+	}
+
+	release()
+	waitFor(t, "deliveries", func() bool { return len(e.requests()) == total && len(queued(t, dir)) == 0 })
+
+	for i, r := range e.requests() {
+		if want := fmt.Sprintf("rec_%03d", i); r.fields["recording"] != want {
+			t.Fatalf("delivery %d has recording %v, want %s", i, r.fields["recording"], want)
+		}
+	}
+}